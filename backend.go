@@ -14,6 +14,23 @@ type Backend interface {
 	Close()
 }
 
+// Gate is implemented by the backends that can tell whether they would write
+// a record before it is formatted.
+type Gate interface {
+	// ShouldLog reports whether the record would be written if logged now.
+	// Only its Level, Module and Time are meant to be set.
+	ShouldLog(rec *Record) bool
+}
+
+// ShouldLog reports whether backend would write rec, which it does unless it
+// implements Gate and says otherwise.
+func ShouldLog(backend Backend, rec *Record) bool {
+	if gate, ok := backend.(Gate); ok {
+		return gate.ShouldLog(rec)
+	}
+	return true
+}
+
 // SetBackend replaces the backend currently set with the given new logging
 // backend.
 func SetBackend(backends ...Backend) LeveledBackend {
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// Compressor compresses rotated log files. Implement it to use a codec other
+// than gzip, e.g. zstd or lz4.
+type Compressor interface {
+	// Extension is appended to the name of compressed files, like ".gz".
+	Extension() string
+	// Compress writes the compressed content of the file src to the file dst.
+	// src is removed by the caller once Compress succeeds.
+	Compress(src, dst string) error
+}
+
+// GzipCompressor compresses rotated files with gzip.
+type GzipCompressor struct {
+	// gzip compression level, 0 means gzip.DefaultCompression
+	Level int
+}
+
+// Extension implements Compressor.
+func (c GzipCompressor) Extension() string {
+	return ".gz"
+}
+
+// Compress implements Compressor.
+func (c GzipCompressor) Compress(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
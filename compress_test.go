@@ -0,0 +1,238 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCompressor(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "zip.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 2
+	fileBackend.Compressor = GzipCompressor{}
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	log := NewLogger("TestFileCompressor")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	for i := 0; i < 5; i++ {
+		log.Info("line", i)
+	}
+
+	date := time.Now().Format("2006-01-02")
+	for _, name := range []string{"zip." + date + ".001.log", "zip." + date + ".002.log"} {
+		path := filepath.Join(dir, name)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			plain, _ := exists(path)
+			zipped, _ := exists(path + ".gz")
+			if !plain && zipped {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not compressed", path)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		f, err := os.Open(path + ".gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Contains(t, string(content), "line")
+	}
+
+	// the compressed name is taken: the next rotation must not reuse it
+	fileBackend.SetMaxLines(1)
+	log.Info("line", 5)
+	ok, _ := exists(filepath.Join(dir, "zip."+date+".003.log"))
+	assert.True(t, ok)
+}
+
+// copyCompressor copies files and records how many copies run at once.
+type copyCompressor struct {
+	active, max int32
+}
+
+func (c *copyCompressor) Extension() string {
+	return ".z"
+}
+
+func (c *copyCompressor) Compress(src, dst string) error {
+	n := atomic.AddInt32(&c.active, 1)
+	defer atomic.AddInt32(&c.active, -1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0600)
+}
+
+func TestFileMaintenanceSerialized(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "burst.log")
+	compressor := &copyCompressor{}
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 1
+	fileBackend.Compressor = compressor
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger("TestFileMaintenanceSerialized")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	for i := 0; i < 50; i++ {
+		log.Info("line", i)
+	}
+	// Close waits for the pending maintenance
+	fileBackend.Close()
+
+	assert.Equal(t, int32(1), compressor.max)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := 0
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".z" {
+			compressed++
+		} else {
+			assert.Equal(t, "burst.log", entry.Name())
+		}
+	}
+	assert.Equal(t, 49, compressed)
+}
+
+func TestFileCompressAfter(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "recent.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 1
+	fileBackend.Compressor = &copyCompressor{}
+	fileBackend.CompressAfter = 2
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		fileBackend.Write([]byte("line"))
+	}
+	fileBackend.Close()
+
+	date := time.Now().Format("2006-01-02")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"recent." + date + ".001.log.z",
+		"recent." + date + ".002.log.z",
+		"recent." + date + ".003.log.z",
+		"recent." + date + ".004.log",
+		"recent." + date + ".005.log",
+		"recent.log",
+	}, names)
+}
+
+func TestFileCompressMinSize(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "sized.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 1
+	fileBackend.Compressor = GzipCompressor{}
+	fileBackend.CompressMinSize = 1024
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 1024)
+	for _, line := range []string{"small", big, "small", big, "current"} {
+		fileBackend.Write([]byte(line))
+	}
+	fileBackend.Close()
+
+	date := time.Now().Format("2006-01-02")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"sized." + date + ".001.log",
+		"sized." + date + ".002.log.gz",
+		"sized." + date + ".003.log",
+		"sized." + date + ".004.log.gz",
+		"sized.log",
+	}, names)
+}
+
+func TestFileBackupFiles(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "list.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 1
+	fileBackend.Compressor = &copyCompressor{}
+	fileBackend.CompressAfter = 1
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	// not created by the backend
+	for _, name := range []string{"list.2013-01-01.log", "list.old.001.log", "other.2013-01-01.001.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, filename, fileBackend.CurrentFile())
+	for i := 0; i < 4; i++ {
+		fileBackend.Write([]byte("line"))
+	}
+	date := time.Now().Format("2006-01-02")
+	expected := []string{
+		filepath.Join(dir, "list."+date+".001.log"),
+		filepath.Join(dir, "list."+date+".002.log"),
+		filepath.Join(dir, "list."+date+".003.log"),
+	}
+	backups, err := fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	// compression may be running
+	for i := range backups {
+		backups[i] = strings.TrimSuffix(backups[i], ".z")
+	}
+	assert.Equal(t, expected, backups)
+	assert.Equal(t, filename, fileBackend.CurrentFile())
+}
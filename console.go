@@ -9,6 +9,9 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
+
+	isatty "github.com/luojiego/go-logging/v2/color"
 )
 
 type color int
@@ -105,6 +108,63 @@ func (b *LogBackend) Log(calldepth int, rec *Record) {
 // Close closes the log service.
 func (b *LogBackend) Close() {}
 
+// ColorMode controls whether ConsoleBackend writes ANSI colors.
+type ColorMode int
+
+// Color modes.
+const (
+	// ColorAuto keeps colors only when the output is a terminal.
+	ColorAuto ColorMode = iota
+	// ColorAlways always writes colors.
+	ColorAlways
+	// ColorNever strips all colors.
+	ColorNever
+)
+
+// ConsoleBackend writes records to os.Stdout or any other writer. By default
+// colors are kept when the output is a terminal and stripped otherwise.
+type ConsoleBackend struct {
+	ForceColor ColorMode
+
+	out      io.Writer
+	terminal bool
+	mu       sync.Mutex
+}
+
+// NewConsoleBackend creates a new ConsoleBackend writing to out, or to
+// os.Stdout if out is nil.
+func NewConsoleBackend(out io.Writer) *ConsoleBackend {
+	if out == nil {
+		out = os.Stdout
+	}
+	b := &ConsoleBackend{out: out}
+	if f, ok := out.(interface{ Fd() uintptr }); ok {
+		b.terminal = isatty.IsTerminal(f.Fd())
+	}
+	return b
+}
+
+// Log implements the Backend interface.
+func (b *ConsoleBackend) Log(calldepth int, rec *Record) {
+	colorful := b.ForceColor == ColorAlways || (b.ForceColor == ColorAuto && b.terminal)
+	msg := []byte(rec.Formatted(calldepth+1, colorful))
+	if !colorful {
+		msg = colorRegexp.ReplaceAll(msg, []byte{})
+	}
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		msg = append(msg, '\n')
+	}
+	b.mu.Lock()
+	_, err := b.out.Write(msg)
+	b.mu.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to Console Log msg:%s [error]%s\n", msg, err.Error())
+	}
+}
+
+// Close closes the log service.
+func (b *ConsoleBackend) Close() {}
+
 // ConvertColors takes a list of ints representing colors for log levels and
 // converts them into strings for ANSI color formatting
 func ConvertColors(colors []int, bold bool) []string {
@@ -77,6 +77,32 @@ func TestLogCallpath(t *testing.T) {
 	testCallpath(t, "%{callpath:3} %{message}", "~.a.b.c")
 }
 
+func TestConsoleBackend(t *testing.T) {
+	format := MustStringFormatter("%{color}%{level}%{color:reset} %{message}")
+	tests := []struct {
+		mode     ColorMode
+		expected string
+	}{
+		// a bytes.Buffer is not a terminal, colors in the message are
+		// stripped as well
+		{ColorAuto, "ERROR red\n"},
+		{ColorNever, "ERROR red\n"},
+		{ColorAlways, "\x1b[31mERROR\x1b[0m \x1b[31mred\x1b[0m\n"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		backend := NewConsoleBackend(buf)
+		backend.ForceColor = test.mode
+		SetBackend(NewBackendFormatter(backend, format))
+
+		log := NewLogger("test")
+		log.Error(ColorSeq(ColorRed) + "red\x1b[0m")
+		if buf.String() != test.expected {
+			t.Errorf("mode %d: %q != %q", test.mode, buf.String(), test.expected)
+		}
+	}
+}
+
 func BenchmarkLogMemoryBackendIgnored(b *testing.B) {
 	backend := SetBackend(NewMemoryBackend(1024))
 	backend.SetLevel(INFO, "")
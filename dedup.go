@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// DedupBackend collapses runs of identical consecutive records, like an error
+// logged in a loop. The first record of a run is forwarded, the identical
+// ones following it within Window are dropped, and a single
+// "<message> (repeated N times)" record is forwarded when the run ends: when
+// a different record arrives, when an identical one arrives after Window, or
+// on Close.
+type DedupBackend struct {
+	Backend Backend
+	// How long a run lasts at most, <= 0 forwards all records
+	Window time.Duration
+
+	mu sync.Mutex
+	// the first record of the current run, start is zero before any
+	level     Level
+	module    string
+	message   string
+	start     time.Time
+	formatter Formatter
+	// records dropped since start
+	repeated int
+	now      func() time.Time
+}
+
+// NewDedupBackend returns a backend collapsing the identical records logged to
+// backend within window.
+func NewDedupBackend(backend Backend, window time.Duration) *DedupBackend {
+	return &DedupBackend{Backend: backend, Window: window, now: time.Now}
+}
+
+// Log implements the Backend interface.
+func (b *DedupBackend) Log(calldepth int, rec *Record) {
+	if b.Window <= 0 {
+		b.Backend.Log(calldepth+1, rec)
+		return
+	}
+	clock := b.now
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock()
+	message := rec.Message()
+
+	// forwarding under the lock keeps the summary before the next record
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.start.IsZero() && rec.Level == b.level && rec.Module == b.module &&
+		message == b.message && now.Sub(b.start) < b.Window {
+		b.repeated++
+		return
+	}
+	b.flush(calldepth+1, rec.Time)
+	b.level, b.module, b.message = rec.Level, rec.Module, message
+	b.start, b.formatter = now, rec.formatter
+	b.Backend.Log(calldepth+1, rec)
+}
+
+// ShouldLog implements the Gate interface. The message is not known
+// beforehand, so it only asks the wrapped backend.
+func (b *DedupBackend) ShouldLog(rec *Record) bool {
+	return ShouldLog(b.Backend, rec)
+}
+
+// flush forwards the summary of the current run, if records were dropped. The
+// caller must hold mu.
+func (b *DedupBackend) flush(calldepth int, t time.Time) {
+	if b.repeated == 0 {
+		return
+	}
+	format := "%s (repeated %d times)"
+	summary := Record{
+		Time:      t,
+		Module:    b.module,
+		Level:     b.level,
+		Args:      []interface{}{b.message, b.repeated},
+		fmt:       &format,
+		formatter: b.formatter,
+	}
+	b.repeated = 0
+	b.Backend.Log(calldepth+1, &summary)
+}
+
+// Close forwards the summary of the current run and closes the wrapped
+// backend.
+func (b *DedupBackend) Close() {
+	b.mu.Lock()
+	b.flush(1, time.Now())
+	b.start = time.Time{}
+	b.mu.Unlock()
+	b.Backend.Close()
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// linesBackend keeps the formatted records it receives.
+type linesBackend struct {
+	sync.Mutex
+	lines  []string
+	closed bool
+}
+
+func (b *linesBackend) Log(calldepth int, rec *Record) {
+	b.Lock()
+	b.lines = append(b.lines, rec.Formatted(calldepth+1, false))
+	b.Unlock()
+}
+
+func (b *linesBackend) Close() {
+	b.closed = true
+}
+
+func TestDedupBackend(t *testing.T) {
+	Reset()
+	inner := &linesBackend{}
+	dedup := NewDedupBackend(inner, time.Second)
+	now := time.Unix(1000, 0)
+	dedup.now = func() time.Time { return now }
+	SetBackend(dedup)
+	log := NewLogger("dedup")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Error("boom")
+			}
+		}()
+	}
+	wg.Wait()
+	log.Error("other")
+	// a run lasts at most the window
+	now = now.Add(time.Second)
+	log.Error("other")
+	log.Error("other")
+	log.Warning("other")
+	log.Warning("other")
+	dedup.Close()
+
+	assert.Equal(t, []string{
+		"boom",
+		"boom (repeated 999 times)",
+		"other",
+		"other",
+		"other (repeated 1 times)",
+		"other",
+		"other (repeated 1 times)",
+	}, inner.lines)
+	assert.True(t, inner.closed)
+}
@@ -41,3 +41,26 @@ func TestExample(t *testing.T) {
 	// error
 	// 00:00:00.000 Example E error
 }
+
+func ExampleLogger_ShouldLog() {
+	Reset()
+	backend := AddModuleLevel(NewLogBackend(os.Stdout, "", 0))
+	backend.SetLevel(INFO, "")
+	SetBackend(backend)
+	log := NewLogger("example")
+
+	// only pay for building the message if it is written
+	if log.ShouldLog(DEBUG) {
+		log.Debugf("state %s", expensiveDump())
+	}
+	if log.ShouldLog(INFO) {
+		log.Infof("state %s", expensiveDump())
+	}
+
+	// Output:
+	// state dumped
+}
+
+func expensiveDump() string {
+	return "dumped"
+}
@@ -1,69 +1,371 @@
 package logging
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // FileBackend implements LoggerInterface.
 // It writes messages by lines limit, file size limit, or time frequency.
+// Set the fields before Start; once logging, change MaxLines, MaxSize, Daily,
+// Rotate and Perm only with their setters.
 type FileBackend struct {
-	sync.Mutex // write log order by order and  atomic incr maxLinesCurLines and maxSizeCurSize
+	sync.Mutex // write log order by order
 	statusLock sync.RWMutex
 	status     int8 // 0:close 1:run
+	started    bool
 	// The opened file
 	Filename   string `json:"filename"`
-	fileWriter *os.File
+	fileWriter logFile
+	// set by close under the write lock, the file is then neither written
+	// nor reopened
+	fileClosed bool
+	// set by NewWriterBackend, written to instead of Filename
+	sink io.Writer
+
+	// Also write every record to os.Stderr, in colors if it is a terminal.
+	// The copy is written by the logging goroutine without holding any lock
+	// of the file, so it never delays the asynchronous writer.
+	TeeStderr bool `json:"teestderr"`
+	tee       *ConsoleBackend
+	teeOnce   sync.Once
+
+	// Also append the records at least as severe as AuditMinLevel, like
+	// WARNING, to the file AuditPath, which is never rotated. Like the
+	// stderr copy it is written by the logging goroutine; a failed write
+	// goes to ErrorHandler and does not stop the main file.
+	AuditPath     string `json:"auditpath"`
+	AuditMinLevel Level  `json:"auditminlevel"`
+	auditLock     sync.Mutex
+	auditFile     *os.File
+
+	// Receives the messages that could not be written to the file, like
+	// os.Stderr, until writing to the file succeeds again
+	Fallback io.Writer `json:"-"`
+	degraded bool
+	// error of the last write, nil if it succeeded
+	lastWriteErr error
+	// When asynchronous, write a line telling how many messages could not
+	// be written, and why, to the file once writing to it succeeds again
+	EmitWriteErrorsToLog bool `json:"emitwriteerrorstolog"`
+	lostMsgs, lostBytes  int
+	lostErr              error
+
+	// Retry a failed write this many times, waiting RetryBackoff before the
+	// first retry and twice as long before each next one. Errors like a
+	// closed file are not retried.
+	WriteRetries int           `json:"writeretries"`
+	RetryBackoff time.Duration `json:"retrybackoff"`
+	// Called with the error of a write that failed for good when there is no
+	// Fallback, and of a failed SyncInterval sync; the error and the message
+	// are printed to stderr if nil
+	ErrorHandler func(error) `json:"-"`
 
 	// Rotate at line
 	MaxLines         int `json:"maxlines"`
-	maxLinesCurLines int
+	maxLinesCurLines atomic.Int64
+	// Count the lines of an existing file when opening it. Scanning a large
+	// file is slow; when false, or when MaxLines <= 0, the count starts at 0.
+	CountLinesOnInit bool `json:"countlinesoninit"`
+	// Size of the reads counting the lines, <= 0 means 32768
+	CountBufferSize int `json:"countbuffersize"`
 
 	// Rotate at size
 	MaxSize        int `json:"maxsize"`
-	maxSizeCurSize int
+	maxSizeCurSize atomic.Int64
 
 	// Rotate daily
-	Daily         bool  `json:"daily"`
-	MaxDays       int64 `json:"maxdays"`
-	dailyOpenDate int
+	Daily bool `json:"daily"`
+	// Delete rotated files older than MaxDays days, <= 0 means never
+	MaxDays       int64        `json:"maxdays"`
+	dailyOpenDate atomic.Int64 // like 20130102, see dateOf
+	// Keep writing to the current file on a new day if it is still empty,
+	// instead of renaming it to an empty dated file
+	SkipEmptyRotation bool `json:"skipemptyrotation"`
 
 	Rotate bool `json:"rotate"`
+	// Digits of the index of the rotated files of a day, which is zero
+	// padded so that the names sort in order; 3 if <= 0, at most 9. A day
+	// has at most 10^IndexWidth-1 rotated files.
+	IndexWidth int `json:"indexwidth"`
+
+	// Empty the file when it is first opened instead of appending to it;
+	// files reopened after a rotation are not affected
+	Truncate bool `json:"truncate"`
+	opened   bool
+
+	// A symbolic link kept pointing at the current file, for tools that
+	// want a stable name. It is left in place on Close so that it still
+	// resolves to the last file written.
+	Symlink       string `json:"symlink"`
+	symlinkWarned bool
 
 	Perm os.FileMode `json:"perm"`
+	// Mode of the directories of Filename created by Start, or again when
+	// they are removed while logging; 0 means 0777. Both modes are masked
+	// by the umask. A file removed while logging, maybe with its
+	// directories, is recreated when a write to it fails, on the next
+	// SyncInterval tick, or when rotating; until then writes are lost.
+	DirPerm os.FileMode `json:"dirperm"`
+
+	// Reserve MaxSize bytes on disk for each new file to reduce
+	// fragmentation. Only done on Linux, it is a no-op elsewhere and on
+	// filesystems without fallocate support. The file size, and so the size
+	// accounting, still only counts the bytes written. The space reserved
+	// beyond them is released when the file is rotated or closed, so that
+	// backups only take the space of their content.
+	Preallocate bool `json:"preallocate"`
+	// Open the file with O_SYNC, so that each write reaches the disk before
+	// it returns. This is much slower, every record waits for the disk.
+	// Log only waits for it when synchronous; asynchronous records are
+	// synced by the writer goroutine after Log returned. With BufferSize,
+	// only the flushes are synced.
+	SyncWrites bool `json:"syncwrites"`
+
+	// Terminates each line and is what lines are counted by, "\n" if empty
+	LineSeparator []byte `json:"-"`
+	// Append LineSeparator to messages not ending with it, true by default;
+	// when false, messages are written exactly as formatted
+	AppendNewline bool `json:"appendnewline"`
+	// Start each line with a sequence number like "0000000042 ", counting
+	// from 1 across rotations. It restarts at 1 with the process. Lines
+	// logged concurrently may be written out of order, but a missing number
+	// is a lost line.
+	SequenceNumbers bool `json:"sequencenumbers"`
+	seq             uint64
+
+	// Buffer writes in memory, 0 means unbuffered. The buffer is flushed
+	// when full, when FlushBytes are buffered, and on every Sync, rotation
+	// and Close.
+	BufferSize int `json:"buffersize"`
+	// Flush once this many bytes are buffered, <= 0 means only when the
+	// buffer is full or on the occasions above
+	FlushBytes int `json:"flushbytes"`
+
+	// Fsync the file periodically, 0 means only on Close
+	SyncInterval   time.Duration `json:"syncinterval"`
+	syncSignalChan chan struct{}
+	syncWg         sync.WaitGroup
+
+	// Check for rotation this often even when nothing is logged, so that a
+	// quiet file is still rotated at midnight; 0 means only when logging
+	RotateCheckInterval time.Duration `json:"rotatecheckinterval"`
+	rotateSignalChan    chan struct{}
+	rotateWg            sync.WaitGroup
+
+	// Called after each successful rotation with the name the file was
+	// renamed to and the name of the new current file. It runs in the
+	// goroutine that rotated, after the write lock is released but while
+	// rotateLock is still held, unless OnRotateAsync is set. When
+	// asynchronous, that goroutine is the writer one, so it must not log to
+	// this backend without OnRotateAsync: once the channel is full the writer
+	// would wait for itself. A panic in it is recovered and printed.
+	OnRotate      func(oldPath, newPath string) `json:"-"`
+	OnRotateAsync bool                          `json:"onrotateasync"`
+	rotateEvents  chan RotateEvent
+	eventsOnce    sync.Once
+	// Returns the last bytes written to a file right before it is rotated or
+	// closed, like a line telling that the file is complete. It is called
+	// holding the write lock, so that the counts it reads, like
+	// CurrentLines, are those of the file it ends; it must not log to the
+	// backend, nor call IsHealthy or SetPerm. It is not written when
+	// CloseWithTimeout gives up on the queued messages.
+	Footer func(w *FileBackend) []byte `json:"-"`
+
+	// Compress rotated files with it, nil means no compression
+	Compressor Compressor `json:"-"`
+	// Leave the newest CompressAfter rotated files uncompressed
+	CompressAfter int `json:"compressafter"`
+	// Leave the rotated files smaller than CompressMinSize bytes
+	// uncompressed, they are still deleted after MaxDays
+	CompressMinSize int64 `json:"compressminsize"`
+
+	// Background compression and deletion run one at a time in a single
+	// worker; requests made while one is pending are dropped.
+	maintainChan chan struct{}
+	maintainOnce sync.Once
+	maintainWg   sync.WaitGroup
 
 	fileNameOnly, suffix string // like "project.log", project is fileNameOnly and .log is suffix
 	// Asynchronous output channels
 	asyncMsgChan    chan []byte
-	asyncSignalChan chan struct{}
+	asyncSignalChan chan struct{} // closed to abandon draining on a close timeout
+	asyncWg         sync.WaitGroup
+	// IsHealthy fails when more messages are queued, 0 means no limit
+	AsyncHighWater int `json:"asynchighwater"`
+	// Write up to BatchSize queued messages at once, waiting up to
+	// BatchTimeout for them; a batch is split where a rotation is needed
+	BatchSize    int           `json:"batchsize"`
+	BatchTimeout time.Duration `json:"batchtimeout"`
+	batchBuf     []byte        // reused by writeBatch, under rotateLock
+
+	// serializes rotations, the write lock is only held to swap the file
+	rotateLock sync.Mutex
+	// guards the rotation settings changed by the setters
+	optLock sync.RWMutex
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// logFile is the part of *os.File the backend writes to.
+type logFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+	Stat() (os.FileInfo, error)
+}
+
+// RotateEvent describes a rotation: the file was renamed to OldPath, and
+// NewPath is the new current file.
+type RotateEvent struct {
+	OldPath, NewPath string
+	Time             time.Time
+}
+
+// rotateEventsLen is how many events wait for a slow reader before new ones
+// are dropped.
+const rotateEventsLen = 16
+
+// bufferedFile buffers the writes to file.
+type bufferedFile struct {
+	*bufio.Writer
+	file       logFile
+	flushBytes int
+}
+
+func (f *bufferedFile) Write(p []byte) (int, error) {
+	n, err := f.Writer.Write(p)
+	if err == nil && f.flushBytes > 0 && f.Buffered() >= f.flushBytes {
+		err = f.Flush()
+	}
+	return n, err
+}
+
+// Sync flushes the buffer and fsyncs the file.
+func (f *bufferedFile) Sync() error {
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
 }
 
+// Close flushes the buffer and closes the file.
+func (f *bufferedFile) Close() error {
+	err := f.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *bufferedFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+// writerFile is the logFile of a backend made by NewWriterBackend.
+type writerFile struct {
+	io.Writer
+}
+
+func (f writerFile) Sync() error  { return nil }
+func (f writerFile) Close() error { return nil }
+
+func (f writerFile) Stat() (os.FileInfo, error) {
+	return nil, errors.New("not a file")
+}
+
+// ErrCloseTimeout is returned by CloseWithTimeout when buffered messages could
+// not be written before the deadline.
+var ErrCloseTimeout = errors.New("logging: close timed out")
+
+// ErrBackendClosed is returned when writing to a backend that is closed.
+var ErrBackendClosed = errors.New("logging: backend closed")
+
+var errNoFilename = errors.New("FileBackend must have filename")
+
 // NewDefaultFileBackend create a FileLogWriter returning as LoggerInterface.
 func NewDefaultFileBackend(filename string, asyncLen ...int) (*FileBackend, error) {
 	if len(filename) == 0 {
-		return nil, errors.New("FileBackend must have filename")
+		return nil, errNoFilename
 	}
 
-	w := &FileBackend{
-		Filename: filename,
-		MaxLines: 1000000,
-		MaxSize:  1 << 28, //256 MB
-		Daily:    true,
-		MaxDays:  7,
-		Rotate:   true,
-		Perm:     0660,
+	w := NewFileBackend(filename)
+	if err := w.Start(asyncLen...); err != nil {
+		return nil, err
 	}
-	if len(asyncLen) > 0 && asyncLen[0] > 0 {
-		w.asyncMsgChan = make(chan []byte, asyncLen[0])
-		w.asyncSignalChan = make(chan struct{})
+	return w, nil
+}
+
+// NewWriterBackend returns a backend writing to w instead of a file. The
+// lines and bytes are still counted and asyncLen works as for
+// NewDefaultFileBackend, but files are never rotated and w is not closed by
+// Close.
+func NewWriterBackend(w io.Writer, asyncLen ...int) (*FileBackend, error) {
+	if w == nil {
+		return nil, errors.New("FileBackend must have a writer")
+	}
+	b := NewFileBackend("")
+	b.sink = w
+	b.Rotate = false
+	if err := b.Start(asyncLen...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewFileBackend returns a FileBackend with the same defaults as
+// NewDefaultFileBackend, but does not open the file. Adjust the fields and
+// call Start before logging.
+func NewFileBackend(filename string) *FileBackend {
+	return &FileBackend{
+		Filename:         filename,
+		MaxLines:         1000000,
+		CountLinesOnInit: true,
+		CountBufferSize:  defaultCountBufferSize,
+		MaxSize:          1 << 28, //256 MB
+		Daily:            true,
+		MaxDays:          7,
+		Rotate:           true,
+		IndexWidth:       defaultIndexWidth,
+		Perm:             0660,
+		DirPerm:          0777,
+		LineSeparator:    []byte{'\n'},
+		AppendNewline:    true,
+	}
+}
+
+// Start opens the log file and starts the background goroutines. If asyncLen
+// is given and greater than zero, messages are written asynchronously through
+// a channel buffering that many messages, at most maxAsyncLen. Giving a
+// negative asyncLen, or more than one, is an error.
+func (w *FileBackend) Start(asyncLen ...int) error {
+	if w.sink == nil && len(w.Filename) == 0 {
+		return errNoFilename
+	}
+	if w.sink == nil {
+		if err := checkFilename(w.Filename); err != nil {
+			return err
+		}
+	}
+	if err := checkAsyncLen(asyncLen); err != nil {
+		return err
+	}
+	if w.started {
+		return errors.New("FileBackend already started")
 	}
 
 	w.suffix = filepath.Ext(w.Filename)
@@ -71,82 +373,617 @@ func NewDefaultFileBackend(filename string, asyncLen ...int) (*FileBackend, erro
 	if w.suffix == "" {
 		w.suffix = ".log"
 	}
-	p, _ := filepath.Split(w.Filename)
-	d, err := os.Stat(p)
-	if err != nil || !d.IsDir() {
-		os.MkdirAll(p, 0777)
+	if dir := filepath.Dir(w.Filename); w.sink == nil && dir != "." {
+		if err := os.MkdirAll(dir, w.dirPerm()); err != nil {
+			return fmt.Errorf("FileBackend: cannot create the directory of %q: %w", w.Filename, err)
+		}
+	}
+	if w.AuditPath != "" {
+		flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+		if w.SyncWrites {
+			flag |= os.O_SYNC
+		}
+		f, err := os.OpenFile(w.AuditPath, flag, w.Perm)
+		if err != nil {
+			return fmt.Errorf("FileBackend: cannot open the audit file: %w", err)
+		}
+		w.auditFile = f
+	}
+	// opening the file now reports permission errors before the first Log
+	if err := w.openFile(); err != nil {
+		// nothing is left open, and no goroutine was started
+		if w.fileWriter != nil {
+			w.fileWriter.Close()
+			w.fileWriter = nil
+		}
+		if w.auditFile != nil {
+			w.auditFile.Close()
+			w.auditFile = nil
+		}
+		return fmt.Errorf("FileBackend: cannot open %q: %w", w.Filename, err)
+	}
+	// only now, so that a failed Start can be retried
+	w.started = true
+	if len(asyncLen) > 0 && asyncLen[0] > 0 {
+		w.asyncMsgChan = make(chan []byte, asyncLen[0])
+		w.asyncSignalChan = make(chan struct{})
 	}
-	err = w.startLogger()
-	return w, err
+	w.status = 1
+	// the goroutines are started once here, not when reopening on rotation
+	if w.asyncMsgChan != nil {
+		w.asyncWg.Add(1)
+		go w.drain()
+	}
+	if w.SyncInterval > 0 {
+		w.syncSignalChan = make(chan struct{})
+		w.syncWg.Add(1)
+		go w.syncLoop(w.SyncInterval)
+	}
+	if w.RotateCheckInterval > 0 && w.sink == nil {
+		w.rotateSignalChan = make(chan struct{})
+		w.rotateWg.Add(1)
+		go w.rotateLoop(w.RotateCheckInterval)
+	}
+	return nil
 }
 
-// start file logger. create log file and set to locker-inside file writer.
-func (w *FileBackend) startLogger() error {
-	file, err := w.createLogFile()
+// Validate checks the configuration without starting the backend, for
+// linting it before use. It returns all the problems found, joined: fields
+// that contradict each other or are out of range, and a target that cannot
+// be written. It creates and removes a probe file to find out whether a
+// directory is writable, but changes neither the backend nor its file.
+func (w *FileBackend) Validate() error {
+	var errs []error
+	check := func(failed bool, format string, args ...interface{}) {
+		if failed {
+			errs = append(errs, fmt.Errorf("FileBackend: "+format, args...))
+		}
+	}
+	w.optLock.RLock()
+	maxLines, maxSize, daily, rotate := w.MaxLines, w.MaxSize, w.Daily, w.Rotate
+	w.optLock.RUnlock()
+	check(rotate && maxLines <= 0 && maxSize <= 0 && !daily,
+		"Rotate is set but MaxLines, MaxSize and Daily never rotate")
+	check(w.Perm&^os.ModePerm != 0, "Perm %v is not only permission bits", w.Perm)
+	check(w.Perm&0200 == 0, "Perm %v does not let the owner write", w.Perm)
+	check(w.DirPerm != 0 && w.DirPerm&0300 != 0300, "DirPerm %v does not let the owner create files", w.DirPerm)
+	check(w.IndexWidth > maxIndexWidth, "IndexWidth %d is more than %d", w.IndexWidth, maxIndexWidth)
+	check(w.BufferSize < 0, "negative BufferSize %d", w.BufferSize)
+	check(w.WriteRetries < 0, "negative WriteRetries %d", w.WriteRetries)
+	check(w.RetryBackoff < 0, "negative RetryBackoff %v", w.RetryBackoff)
+	check(w.SyncInterval < 0, "negative SyncInterval %v", w.SyncInterval)
+	check(w.RotateCheckInterval < 0, "negative RotateCheckInterval %v", w.RotateCheckInterval)
+	check(w.CompressAfter < 0, "negative CompressAfter %d", w.CompressAfter)
+	check(w.Compressor == nil && (w.CompressAfter > 0 || w.CompressMinSize > 0),
+		"CompressAfter or CompressMinSize is set without a Compressor")
+	check(w.AuditPath != "" && w.AuditMinLevel == OFF, "AuditPath is set but AuditMinLevel is OFF")
+	if w.sink != nil {
+		return errors.Join(errs...)
+	}
+	if w.Filename == "" {
+		return errors.Join(append(errs, errNoFilename)...)
+	}
+	if err := checkFilename(w.Filename); err != nil {
+		errs = append(errs, err)
+	} else if err := probeWritable(w.Filename); err != nil {
+		errs = append(errs, fmt.Errorf("FileBackend: %q is not writable: %w", w.Filename, err))
+	}
+	if w.AuditPath != "" {
+		if err := probeWritable(w.AuditPath); err != nil {
+			errs = append(errs, fmt.Errorf("FileBackend: AuditPath %q is not writable: %w", w.AuditPath, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// probeWritable reports whether filename can be opened for writing, or else
+// created in the nearest existing directory above it.
+func probeWritable(filename string) error {
+	if info, err := os.Stat(filename); err == nil {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("not a regular file")
+		}
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	dir := filepath.Dir(filename)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil && !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", dir)
+		}
+		if err == nil {
+			break
+		}
+		if parent := filepath.Dir(dir); parent != dir {
+			dir = parent
+			continue
+		}
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".probe-*")
 	if err != nil {
 		return err
 	}
-	if w.fileWriter != nil {
-		w.fileWriter.Close()
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// maxAsyncLen caps the channel buffering the asynchronous messages, 12MB
+// of slice headers alone.
+const maxAsyncLen = 1 << 19
+
+func checkAsyncLen(asyncLen []int) error {
+	if len(asyncLen) > 1 {
+		return fmt.Errorf("FileBackend: one asyncLen expected, got %d", len(asyncLen))
 	}
-	w.fileWriter = file
-	err = w.initFd()
-	if err == nil {
-		w.status = 1
-		if w.asyncMsgChan != nil {
-			go func() {
-				for {
-					select {
-					case msg := <-w.asyncMsgChan:
-						w.write(msg)
-					case <-w.asyncSignalChan:
-						return
-					}
+	if len(asyncLen) == 1 && asyncLen[0] < 0 {
+		return fmt.Errorf("FileBackend: negative asyncLen %d", asyncLen[0])
+	}
+	if len(asyncLen) == 1 && asyncLen[0] > maxAsyncLen {
+		return fmt.Errorf("FileBackend: asyncLen %d is more than %d", asyncLen[0], maxAsyncLen)
+	}
+	return nil
+}
+
+// checkFilename rejects the names that cannot be a log file.
+func checkFilename(filename string) error {
+	if os.IsPathSeparator(filename[len(filename)-1]) {
+		return fmt.Errorf("FileBackend: filename %q ends with a path separator", filename)
+	}
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return fmt.Errorf("FileBackend: filename %q is a directory", filename)
+	}
+	return nil
+}
+
+// drain writes the messages queued in asynchronous mode.
+func (w *FileBackend) drain() {
+	defer w.asyncWg.Done()
+	var batch [][]byte
+	for msg := range w.asyncMsgChan {
+		select {
+		case <-w.asyncSignalChan:
+			return
+		default:
+		}
+		batch = append(batch[:0], msg)
+		if w.BatchSize > 1 {
+			batch = w.collectBatch(batch)
+		}
+		// checking and writing under rotateLock keeps the counters exact
+		w.rotateLock.Lock()
+		w.writeBatch(batch, timeNow())
+		w.rotateLock.Unlock()
+	}
+}
+
+// collectBatch adds the queued messages to batch, up to BatchSize of them,
+// waiting at most BatchTimeout for more.
+func (w *FileBackend) collectBatch(batch [][]byte) [][]byte {
+	var timeout <-chan time.Time
+	if w.BatchTimeout > 0 {
+		timer := time.NewTimer(w.BatchTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < w.BatchSize {
+		select {
+		case msg, ok := <-w.asyncMsgChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, msg)
+			continue
+		default:
+		}
+		if timeout == nil {
+			return batch
+		}
+		select {
+		case msg, ok := <-w.asyncMsgChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, msg)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch writes msgs with as few writes as the rotation settings allow,
+// rotating between them as needed. The caller must hold rotateLock.
+func (w *FileBackend) writeBatch(msgs [][]byte, t time.Time) {
+	if len(msgs) == 1 {
+		w.rotateIfNeeded(t)
+		w.write(msgs[0])
+		return
+	}
+	buf := w.batchBuf[:0]
+	lines := 0
+	rotates := w.rotates()
+	for _, msg := range msgs {
+		// the file must not grow past the point a rotation is needed
+		if len(buf) > 0 && rotates && w.needRotate(lines, len(buf), dateOf(t)) {
+			w.writeLines(buf, lines)
+			buf, lines = buf[:0], 0
+		}
+		if len(buf) == 0 {
+			w.rotateIfNeeded(t)
+		}
+		buf = append(buf, msg...)
+		lines += w.msgLines(msg)
+	}
+	w.writeLines(buf, lines)
+	w.batchBuf = buf
+}
+
+// syncLoop fsyncs the file every interval, and recreates it if it was
+// removed. It holds the write lock so it never syncs a file that is being
+// rotated. Failures go to ErrorHandler.
+func (w *FileBackend) syncLoop(interval time.Duration) {
+	defer w.syncWg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.Lock()
+			// writes to a removed file succeed, they are lost
+			_, err := w.reopenIfRemoved()
+			if err == nil && w.fileWriter != nil {
+				err = w.fileWriter.Sync()
+			}
+			w.Unlock()
+			if err != nil {
+				if w.ErrorHandler != nil {
+					w.ErrorHandler(err)
+				} else {
+					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
 				}
-			}()
+			}
+		case <-w.syncSignalChan:
+			return
+		}
+	}
+}
+
+// rotateLoop rotates the file every interval if needed, as a write would.
+func (w *FileBackend) rotateLoop(interval time.Duration) {
+	defer w.rotateWg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.rotateLock.Lock()
+			w.rotateIfNeeded(timeNow())
+			w.rotateLock.Unlock()
+		case <-w.rotateSignalChan:
+			return
 		}
 	}
-	return err
 }
 
-func (w *FileBackend) needRotate(size int, day int) bool {
-	return (w.MaxLines > 0 && w.maxLinesCurLines >= w.MaxLines) ||
-		(w.MaxSize > 0 && w.maxSizeCurSize >= w.MaxSize) ||
-		(w.Daily && day != w.dailyOpenDate)
+// needRotate reports whether the file is full, counting lines lines of size
+// bytes not written yet, or was opened on another date than date.
+func (w *FileBackend) needRotate(lines, size int, date int) bool {
+	w.optLock.RLock()
+	maxLines, maxSize, daily := w.MaxLines, w.MaxSize, w.Daily
+	w.optLock.RUnlock()
+	// read without any lock, a value changed meanwhile by a rotation is
+	// checked again under rotateLock by rotateIfNeeded
+	curLines := w.maxLinesCurLines.Load() + int64(lines)
+	curSize := w.maxSizeCurSize.Load() + int64(size)
+	return (maxLines > 0 && curLines >= int64(maxLines)) ||
+		(maxSize > 0 && curSize >= int64(maxSize)) ||
+		(daily && int64(date) != w.dailyOpenDate.Load())
+
+}
+
+// shouldRotate reports whether the file must be rotated before writing at t.
+func (w *FileBackend) shouldRotate(t time.Time) bool {
+	return w.rotates() && w.needRotate(0, 0, dateOf(t))
+}
 
+// rotates reports whether the file is ever rotated.
+func (w *FileBackend) rotates() bool {
+	w.optLock.RLock()
+	rotate := w.Rotate
+	w.optLock.RUnlock()
+	return rotate && w.sink == nil
+}
+
+// rotateIfNeeded rotates the file if it must be before writing at t. The
+// caller must hold rotateLock.
+func (w *FileBackend) rotateIfNeeded(t time.Time) {
+	if w.shouldRotate(t) {
+		if err := w.doRotate(t); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
+		}
+	}
+}
+
+// SetMaxLines changes MaxLines while logging.
+func (w *FileBackend) SetMaxLines(maxLines int) {
+	w.optLock.Lock()
+	w.MaxLines = maxLines
+	w.optLock.Unlock()
+}
+
+// SetMaxSize changes MaxSize while logging.
+func (w *FileBackend) SetMaxSize(maxSize int) {
+	w.optLock.Lock()
+	w.MaxSize = maxSize
+	w.optLock.Unlock()
+}
+
+// SetDaily changes Daily while logging.
+func (w *FileBackend) SetDaily(daily bool) {
+	w.optLock.Lock()
+	w.Daily = daily
+	w.optLock.Unlock()
+}
+
+// SetRotate changes Rotate while logging.
+func (w *FileBackend) SetRotate(rotate bool) {
+	w.optLock.Lock()
+	w.Rotate = rotate
+	w.optLock.Unlock()
+}
+
+// SetPerm changes Perm while logging. The current file, and the audit file,
+// are changed to mode at once; the next rotated files are created with it.
+func (w *FileBackend) SetPerm(mode os.FileMode) error {
+	w.Lock()
+	defer w.Unlock()
+	w.Perm = mode
+	if w.sink != nil {
+		return nil
+	}
+	err := os.Chmod(w.Filename, mode)
+	w.auditLock.Lock()
+	if w.auditFile != nil {
+		if auditErr := w.auditFile.Chmod(mode); err == nil {
+			err = auditErr
+		}
+	}
+	w.auditLock.Unlock()
+	return err
 }
 
 var colorRegexp = regexp.MustCompile("\x1b\\[[0-9]{1,2}m")
 
+// bufferPool holds the buffers records are formatted into by Log.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBuffer keeps the buffer of an unusually long line from being held
+// by the pool.
+const maxPooledBuffer = 64 << 10
+
+// stripColors removes the sequences matched by colorRegexp from b in place.
+func stripColors(b []byte) []byte {
+	i := bytes.IndexByte(b, '\x1b')
+	if i < 0 {
+		return b
+	}
+	out := b[:i]
+	for i < len(b) {
+		if n := colorSeqLen(b[i:]); n > 0 {
+			i += n
+			continue
+		}
+		out = append(out, b[i])
+		i++
+	}
+	return out
+}
+
+// colorSeqLen returns the length of the color sequence b starts with, or 0.
+func colorSeqLen(b []byte) int {
+	if len(b) < 4 || b[0] != '\x1b' || b[1] != '[' {
+		return 0
+	}
+	n := 2
+	for n < len(b) && n < 4 && b[n] >= '0' && b[n] <= '9' {
+		n++
+	}
+	if n == 2 || n == len(b) || b[n] != 'm' {
+		return 0
+	}
+	return n + 1
+}
+
 // Log implements the Backend interface.
 func (w *FileBackend) Log(calldepth int, rec *Record) {
+	w.log(context.Background(), calldepth+1, rec)
+}
+
+// LogContext is like Log, but gives up and returns ctx.Err() if ctx is done
+// before the record is queued in asynchronous mode, or before it is written
+// otherwise.
+func (w *FileBackend) LogContext(ctx context.Context, calldepth int, rec *Record) error {
+	return w.log(ctx, calldepth+1, rec)
+}
+
+func (w *FileBackend) log(ctx context.Context, calldepth int, rec *Record) error {
 	w.statusLock.RLock()
 	if w.status == 0 {
 		w.statusLock.RUnlock()
+		return ErrBackendClosed
+	}
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if w.SequenceNumbers {
+		fmt.Fprintf(buf, seqFormat, atomic.AddUint64(&w.seq, 1))
+	}
+	if rec.formatted != "" {
+		buf.WriteString(rec.formatted)
+	} else {
+		rec.formatter.Format(calldepth+1, false, rec, buf)
+	}
+	msg := stripColors(buf.Bytes())
+	if w.asyncMsgChan != nil {
+		// queued messages outlive the buffer
+		msg = append(make([]byte, 0, len(msg)+1), msg...)
+	}
+	err := w.output(ctx, msg, rec.Time)
+	// a record given up on, or refused by a closing backend, is not audited
+	given := err == nil || (err != ctx.Err() && !errors.Is(err, ErrBackendClosed))
+	if w.AuditPath != "" && given && rec.Level != OFF && rec.Level <= w.AuditMinLevel {
+		w.audit(msg)
+	}
+	w.statusLock.RUnlock()
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+	if w.TeeStderr {
+		w.teeOnce.Do(func() {
+			w.tee = NewConsoleBackend(os.Stderr)
+		})
+		w.tee.Log(calldepth+1, rec)
+	}
+	return err
+}
+
+// audit appends msg to the audit file, unless it is closed. The caller must
+// hold statusLock for reading.
+func (w *FileBackend) audit(msg []byte) {
+	w.auditLock.Lock()
+	if w.auditFile == nil {
+		w.auditLock.Unlock()
 		return
 	}
-	msg := colorRegexp.ReplaceAll([]byte(rec.Formatted(calldepth+1, false)), []byte{})
-	if msg[len(msg)-1] != '\n' {
-		msg = append(msg, '\n')
+	_, err := w.auditFile.Write(msg)
+	if sep := w.lineSeparator(); err == nil && w.AppendNewline && !bytes.HasSuffix(msg, sep) {
+		_, err = w.auditFile.Write(sep)
 	}
-	d := rec.Time.Day()
-	if w.Rotate {
-		if w.needRotate(len(msg), d) {
-			w.Lock()
-			if w.needRotate(len(msg), d) {
-				if err := w.doRotate(rec.Time); err != nil {
-					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
-				}
-			}
-			w.Unlock()
+	w.auditLock.Unlock()
+	if err != nil {
+		if w.ErrorHandler != nil {
+			w.ErrorHandler(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): audit: %s\n", w.Filename, err)
 		}
 	}
-	if w.asyncMsgChan != nil {
-		w.asyncMsgChan <- msg
+}
+
+// seqFormat formats the sequence numbers, seqLen bytes long below 10^10.
+const (
+	seqFormat = "%010d "
+	seqLen    = 11
+)
+
+// ShouldLog implements the Gate interface, false once the backend is closed.
+func (w *FileBackend) ShouldLog(rec *Record) bool {
+	w.statusLock.RLock()
+	defer w.statusLock.RUnlock()
+	return w.status != 0
+}
+
+// Write implements io.Writer, so the backend can be the output of a standard
+// library log.Logger or anything else taking an io.Writer. Each call is
+// treated as one pre-formatted line and goes through the same rotation and
+// asynchronous path as Log; the line separator is appended if it is missing,
+// unless AppendNewline is false.
+func (w *FileBackend) Write(p []byte) (int, error) {
+	w.statusLock.RLock()
+	defer w.statusLock.RUnlock()
+	if w.status == 0 {
+		return 0, ErrBackendClosed
+	}
+	// p must not be retained, and queued messages outlive the call
+	var msg []byte
+	if w.SequenceNumbers {
+		msg = fmt.Appendf(make([]byte, 0, seqLen+len(p)+1), seqFormat, atomic.AddUint64(&w.seq, 1))
+		msg = append(msg, p...)
 	} else {
-		w.write(msg)
+		msg = make([]byte, len(p), len(p)+1)
+		copy(msg, p)
+	}
+	if err := w.output(context.Background(), msg, timeNow()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteRaw writes p, a line formatted by the caller, exactly as given: unlike
+// Write it never adds a sequence number, and unlike Log it builds no Record
+// and strips no colors. Only the line separator is appended as for Write. It
+// is counted, rotated and queued like any other line.
+func (w *FileBackend) WriteRaw(p []byte) error {
+	w.statusLock.RLock()
+	defer w.statusLock.RUnlock()
+	if w.status == 0 {
+		return ErrBackendClosed
+	}
+	// p must not be retained, and queued messages outlive the call
+	msg := make([]byte, len(p), len(p)+len(w.lineSeparator()))
+	copy(msg, p)
+	return w.output(context.Background(), msg, timeNow())
+}
+
+// output rotates the file if needed and writes msg, or queues it when
+// asynchronous. The caller must hold statusLock for reading.
+func (w *FileBackend) output(ctx context.Context, msg []byte, t time.Time) error {
+	if sep := w.lineSeparator(); w.AppendNewline && !bytes.HasSuffix(msg, sep) {
+		msg = append(msg, sep...)
 	}
+	// when asynchronous, the writer goroutine rotates right before writing
+	// so that the counters include all queued messages
+	if w.asyncMsgChan == nil && w.shouldRotate(t) {
+		// if another goroutine is already rotating, keep writing to the
+		// current file instead of waiting for it
+		if w.rotateLock.TryLock() {
+			w.rotateIfNeeded(t)
+			w.rotateLock.Unlock()
+		}
+	}
+	if w.asyncMsgChan != nil {
+		select {
+		case w.asyncMsgChan <- msg:
+		case <-w.asyncSignalChan:
+			return ErrBackendClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return w.write(msg)
+}
+
+// IsHealthy returns an error if the backend is closed, if its last write
+// failed, or if more than AsyncHighWater messages are waiting to be written.
+// It is meant for readiness checks.
+func (w *FileBackend) IsHealthy() error {
+	w.statusLock.RLock()
+	running := w.status != 0
 	w.statusLock.RUnlock()
+	if !running {
+		return ErrBackendClosed
+	}
+	w.Lock()
+	err := w.lastWriteErr
+	w.Unlock()
+	if err != nil {
+		return fmt.Errorf("logging: last write failed: %w", err)
+	}
+	if queued := len(w.asyncMsgChan); w.AsyncHighWater > 0 && queued > w.AsyncHighWater {
+		return fmt.Errorf("logging: %d messages queued, more than %d", queued, w.AsyncHighWater)
+	}
+	return nil
 }
 
 // Close close the file description, close file writer.
@@ -155,61 +992,398 @@ func (w *FileBackend) Log(calldepth int, rec *Record) {
 // there are no buffering messages in file logger in memory.
 // flush file means sync file from disk.
 func (w *FileBackend) Close() {
-	w.statusLock.Lock()
-	if w.status == 0 {
-		w.statusLock.Unlock()
-		return
+	w.close(nil)
+}
+
+// CloseWithTimeout is like Close but stops draining the buffered channel once
+// d has elapsed. Messages still queued at the deadline are discarded and the
+// returned error wraps ErrCloseTimeout with their count. The file is synced
+// and closed in either case. A single write blocked in the OS is not
+// interrupted, CloseWithTimeout waits for it before closing the file.
+func (w *FileBackend) CloseWithTimeout(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	return w.close(t.C)
+}
+
+func (w *FileBackend) close(timeout <-chan time.Time) error {
+	w.statusLock.RLock()
+	running := w.status != 0
+	w.statusLock.RUnlock()
+	if !running {
+		return nil
 	}
-	w.status = 0
-	w.statusLock.Unlock()
-	if w.asyncSignalChan != nil {
-		w.asyncSignalChan <- struct{}{}
-		close(w.asyncSignalChan)
-		close(w.asyncMsgChan)
-		for msg := range w.asyncMsgChan {
-			w.write(msg)
+	w.closeOnce.Do(func() {
+		if w.syncSignalChan != nil {
+			close(w.syncSignalChan)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			// waits for in-flight Log calls; none can start after this
+			w.statusLock.Lock()
+			w.status = 0
+			w.statusLock.Unlock()
+			if w.asyncMsgChan != nil {
+				close(w.asyncMsgChan)
+				w.asyncWg.Wait()
+			}
+			if w.rotateSignalChan != nil {
+				close(w.rotateSignalChan)
+				w.rotateWg.Wait()
+			}
+			// no rotation can request maintenance anymore
+			w.maintainOnce.Do(func() {})
+			if w.maintainChan != nil {
+				close(w.maintainChan)
+				w.maintainWg.Wait()
+			}
+			close(w.events())
+			close(stopped)
+		}()
+		stoppedInTime := false
+		select {
+		case <-stopped:
+			stoppedInTime = true
+		case <-timeout:
+			// unblocks senders and makes the writer goroutine give up
+			if w.asyncSignalChan != nil {
+				close(w.asyncSignalChan)
+			}
+			w.closeErr = fmt.Errorf("%w: %d messages not written", ErrCloseTimeout, len(w.asyncMsgChan))
+		}
+		// a sync in progress is not raced by the last one
+		w.syncWg.Wait()
+		// after a timeout the writer goroutine may still be writing its last
+		// message, or rotating; it finds the file closed afterwards
+		w.Lock()
+		w.fileClosed = true
+		if w.fileWriter != nil {
+			if stoppedInTime {
+				w.writeFooter()
+			}
+			w.releasePreallocated()
+			w.fileWriter.Sync()
+			w.fileWriter.Close()
+			w.fileWriter = nil
+		}
+		w.Unlock()
+		w.auditLock.Lock()
+		if w.auditFile != nil {
+			w.auditFile.Sync()
+			w.auditFile.Close()
+			w.auditFile = nil
 		}
+		w.auditLock.Unlock()
+	})
+	return w.closeErr
+}
+
+func (w *FileBackend) write(msg []byte) error {
+	return w.writeLines(msg, w.msgLines(msg))
+}
+
+// msgLines returns the number of lines msg adds to the file. Without
+// AppendNewline it holds as many as it has separators, maybe none.
+func (w *FileBackend) msgLines(msg []byte) int {
+	if w.AppendNewline {
+		return 1
 	}
-	w.fileWriter.Sync()
-	w.fileWriter.Close()
+	return bytes.Count(msg, w.lineSeparator())
 }
 
-func (w *FileBackend) write(msg []byte) {
+// writeLines writes msg holding the given number of lines to the file.
+func (w *FileBackend) writeLines(msg []byte, lines int) error {
 	w.Lock()
-	_, err := w.fileWriter.Write(msg)
+	if w.fileClosed {
+		// abandoned by CloseWithTimeout, like the messages still queued
+		w.Unlock()
+		return ErrBackendClosed
+	}
+	var err error
+	if w.fileWriter == nil {
+		// the file could not be reopened when rotating, retry
+		err = w.openFile()
+	}
+	if err == nil && w.lostMsgs > 0 {
+		// still failing, msg is lost too
+		err = w.writeLost()
+	}
+	n := 0
 	if err == nil {
-		w.maxLinesCurLines++
-		w.maxSizeCurSize += len(msg)
+		n, err = w.writeFile(msg)
+	}
+	if err != nil && n == 0 {
+		if reopened, openErr := w.reopenIfRemoved(); reopened && openErr == nil {
+			n, err = w.writeFile(msg)
+		}
+	}
+	if err != nil && w.EmitWriteErrorsToLog && w.asyncMsgChan != nil {
+		w.lostMsgs++
+		w.lostBytes += len(msg)
+		w.lostErr = err
+	}
+	if n < len(msg) {
+		// count what reached the file
+		lines = bytes.Count(msg[:n], w.lineSeparator())
+	}
+	if n > 0 {
+		w.maxLinesCurLines.Add(int64(lines))
+		w.maxSizeCurSize.Add(int64(n))
+	}
+	w.lastWriteErr = err
+	fallback := w.Fallback != nil
+	if fallback {
+		w.writeFallback(msg, err)
 	}
 	w.Unlock()
+	if err != nil && !fallback {
+		if w.ErrorHandler != nil {
+			w.ErrorHandler(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "unable to File Log msg:%s [error]%s\n", msg, err.Error())
+		}
+	}
+	return err
+}
+
+// writeLost writes the line telling about the messages lost since the last
+// successful write. If it fails it is tried again before the next message,
+// it is never counted as lost itself. The caller must hold the write lock.
+func (w *FileBackend) writeLost() error {
+	line := fmt.Appendf(nil, "FileLogWriter(%q): %d messages of %d bytes not written: %s",
+		w.Filename, w.lostMsgs, w.lostBytes, w.lostErr)
+	line = append(line, w.lineSeparator()...)
+	n, err := w.writeFile(line)
+	if n > 0 {
+		w.maxLinesCurLines.Add(int64(bytes.Count(line[:n], w.lineSeparator())))
+		w.maxSizeCurSize.Add(int64(n))
+	}
+	if err == nil {
+		w.lostMsgs, w.lostBytes, w.lostErr = 0, 0, nil
+	}
+	return err
+}
+
+// writeFile writes msg to the file, retrying as configured, and returns how
+// many bytes were written. A short write goes on with the rest. The caller
+// must hold the write lock.
+func (w *FileBackend) writeFile(msg []byte) (int, error) {
+	backoff := w.RetryBackoff
+	written := 0
+	for retry := 0; ; {
+		n, err := w.fileWriter.Write(msg[written:])
+		written += n
+		if err == nil && written == len(msg) {
+			return written, nil
+		}
+		if err == nil {
+			if n > 0 {
+				continue
+			}
+			err = io.ErrShortWrite
+		}
+		if retry >= w.WriteRetries || isPermanentWriteError(err) {
+			if written > 0 {
+				err = fmt.Errorf("wrote %d of %d bytes: %w", written, len(msg), err)
+			}
+			return written, err
+		}
+		retry++
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isPermanentWriteError reports whether retrying a write failing with err is
+// pointless.
+func isPermanentWriteError(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, os.ErrInvalid) ||
+		errors.Is(err, syscall.EBADF)
+}
+
+// writeFallback writes msg to Fallback if err is the error writing it to the
+// file, and reports entering and leaving the degraded mode there once. The
+// caller must hold the write lock.
+func (w *FileBackend) writeFallback(msg []byte, err error) {
+	if err == nil {
+		if w.degraded {
+			w.degraded = false
+			fmt.Fprintf(w.Fallback, "FileLogWriter(%q): writing to the file again\n", w.Filename)
+		}
+		return
+	}
+	if !w.degraded {
+		w.degraded = true
+		fmt.Fprintf(w.Fallback, "FileLogWriter(%q): %s, writing here until the file is writable\n", w.Filename, err)
+	}
+	w.Fallback.Write(msg)
+}
+
+// openFile opens the file and makes it the one written to. The caller must
+// hold the write lock unless the backend is starting.
+func (w *FileBackend) openFile() error {
+	if w.sink != nil {
+		return w.openSink()
+	}
+	file, err := w.createLogFile()
+	if err != nil {
+		return err
+	}
+	w.optLock.RLock()
+	maxSize := w.MaxSize
+	w.optLock.RUnlock()
+	if w.Preallocate && maxSize > 0 {
+		if err := preallocate(file, int64(maxSize)); err != nil {
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): preallocate: %s\n", w.Filename, err)
+		}
+	}
+	if w.fileWriter != nil {
+		w.fileWriter.Close()
+	}
+	if w.BufferSize > 0 {
+		w.fileWriter = &bufferedFile{Writer: bufio.NewWriterSize(file, w.BufferSize), file: file, flushBytes: w.FlushBytes}
+	} else {
+		w.fileWriter = file
+	}
+	w.updateSymlink()
+	return w.initFd()
+}
+
+// releasePreallocated truncates the file to its size, which frees the space
+// reserved by Preallocate beyond it. The caller must hold the write lock.
+func (w *FileBackend) releasePreallocated() {
+	if !w.Preallocate {
+		return
+	}
+	var f *os.File
+	switch fw := w.fileWriter.(type) {
+	case *os.File:
+		f = fw
+	case *bufferedFile:
+		// the size must include the buffered bytes
+		if fw.Flush() != nil {
+			return
+		}
+		f, _ = fw.file.(*os.File)
+	}
+	if f == nil {
+		return
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = f.Truncate(info.Size())
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "unable to File Log msg:%s [error]%s\n", msg, err.Error())
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): preallocate: %s\n", w.Filename, err)
+	}
+}
+
+// reopenIfRemoved opens the file again if it was removed while logging, maybe
+// with its directory, and reports whether it did. The caller must hold the
+// write lock.
+func (w *FileBackend) reopenIfRemoved() (bool, error) {
+	if w.sink != nil || w.fileClosed {
+		return false, nil
+	}
+	if _, err := os.Lstat(w.Filename); !errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return true, w.openFile()
+}
+
+// openSink is openFile for a backend made by NewWriterBackend.
+func (w *FileBackend) openSink() error {
+	if w.BufferSize > 0 {
+		w.fileWriter = &bufferedFile{Writer: bufio.NewWriterSize(w.sink, w.BufferSize), file: writerFile{w.sink}, flushBytes: w.FlushBytes}
+	} else {
+		w.fileWriter = writerFile{w.sink}
+	}
+	w.maxSizeCurSize.Store(0)
+	w.maxLinesCurLines.Store(0)
+	w.dailyOpenDate.Store(int64(dateOf(timeNow())))
+	return nil
+}
+
+// updateSymlink points Symlink at the current file. The link is created under
+// a temporary name and renamed over the old one, so readers never see it
+// missing. Where links cannot be created it only warns, once.
+func (w *FileBackend) updateSymlink() {
+	if w.Symlink == "" {
+		return
+	}
+	target, err := filepath.Abs(w.Filename)
+	if err == nil {
+		tmp := w.Symlink + ".tmp"
+		os.Remove(tmp)
+		if err = os.Symlink(target, tmp); err == nil {
+			if err = os.Rename(tmp, w.Symlink); err != nil {
+				os.Remove(tmp)
+			}
+		}
+	}
+	if err != nil && !w.symlinkWarned {
+		w.symlinkWarned = true
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): cannot update symlink: %s\n", w.Filename, err)
 	}
 }
 
 func (w *FileBackend) createLogFile() (*os.File, error) {
 	// Open the log file
-	fd, err := os.OpenFile(w.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, w.Perm)
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if w.Truncate && !w.opened {
+		flag = os.O_WRONLY | os.O_TRUNC | os.O_CREATE
+	}
+	if w.SyncWrites {
+		flag |= os.O_SYNC
+	}
+	fd, err := os.OpenFile(w.Filename, flag, w.Perm)
+	if dir := filepath.Dir(w.Filename); errors.Is(err, fs.ErrNotExist) && dir != "." {
+		// the directory was removed while logging
+		if err = os.MkdirAll(dir, w.dirPerm()); err == nil {
+			fd, err = os.OpenFile(w.Filename, flag, w.Perm)
+		}
+	}
+	if err == nil {
+		w.opened = true
+	}
 	return fd, err
 }
 
+func (w *FileBackend) dirPerm() os.FileMode {
+	if w.DirPerm == 0 {
+		return 0777
+	}
+	return w.DirPerm
+}
+
 func (w *FileBackend) initFd() error {
 	fd := w.fileWriter
 	fInfo, err := fd.Stat()
 	if err != nil {
 		return fmt.Errorf("get stat err: %s\n", err)
 	}
-	w.maxSizeCurSize = int(fInfo.Size())
-	w.dailyOpenDate = time.Now().Day()
-	w.maxLinesCurLines = 0
-	if fInfo.Size() > 0 {
-		count, err := w.lines()
-		if err != nil {
-			return err
+	count := 0
+	w.optLock.RLock()
+	maxLines := w.MaxLines
+	w.optLock.RUnlock()
+	if fInfo.Size() > 0 && maxLines > 0 && w.CountLinesOnInit {
+		if count, err = w.lines(); err != nil {
+			count = 0
 		}
-		w.maxLinesCurLines = count
 	}
-	return nil
+	w.maxSizeCurSize.Store(fInfo.Size())
+	w.maxLinesCurLines.Store(int64(count))
+	w.dailyOpenDate.Store(int64(dateOf(timeNow())))
+	return err
+}
+
+const defaultCountBufferSize = 32768 // 32k
+
+// countBufferPool holds the buffers of lines, which runs on every reopen.
+var countBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
 }
 
 func (w *FileBackend) lines() (int, error) {
@@ -219,17 +1393,33 @@ func (w *FileBackend) lines() (int, error) {
 	}
 	defer fd.Close()
 
-	buf := make([]byte, 32768) // 32k
+	size := w.CountBufferSize
+	if size <= len(w.lineSeparator()) {
+		size = defaultCountBufferSize
+	}
+	bufp := countBufferPool.Get().(*[]byte)
+	defer countBufferPool.Put(bufp)
+	if len(*bufp) != size {
+		*bufp = make([]byte, size)
+	}
+	buf := *bufp
 	count := 0
-	lineSep := []byte{'\n'}
+	lineSep := w.lineSeparator()
+	// the bytes kept from the previous read, a separator may span two reads
+	keep := 0
 
 	for {
-		c, err := fd.Read(buf)
+		c, err := fd.Read(buf[keep:])
 		if err != nil && err != io.EOF {
 			return count, err
 		}
 
+		c += keep
 		count += bytes.Count(buf[:c], lineSep)
+		keep = 0
+		if c >= len(lineSep) {
+			keep = copy(buf, buf[c-len(lineSep)+1:c])
+		}
 
 		if err == io.EOF {
 			break
@@ -239,12 +1429,57 @@ func (w *FileBackend) lines() (int, error) {
 	return count, nil
 }
 
-const maxFileIndex = 999
+var defaultLineSeparator = []byte{'\n'}
+
+func (w *FileBackend) lineSeparator() []byte {
+	if len(w.LineSeparator) == 0 {
+		return defaultLineSeparator
+	}
+	return w.LineSeparator
+}
+
+const (
+	defaultIndexWidth = 3
+	maxIndexWidth     = 9
+)
+
+// indexWidth returns the width of the index of the rotated files, and the
+// largest index.
+func (w *FileBackend) indexWidth() (width, max int) {
+	width = w.IndexWidth
+	if width <= 0 {
+		width = defaultIndexWidth
+	} else if width > maxIndexWidth {
+		width = maxIndexWidth
+	}
+	max = 1
+	for i := 0; i < width; i++ {
+		max *= 10
+	}
+	return width, max - 1
+}
+
+// dateOf returns the calendar date of t as a number like 20130102, so that
+// dates of different months or years never compare equal.
+func dateOf(t time.Time) int {
+	y, m, d := t.Date()
+	return y*10000 + int(m)*100 + d
+}
 
 // DoRotate means it need to write file in new file.
 // new file name like xx.2013-01-01.log (daily) or xx.001.log (by line or size)
+// The caller must hold rotateLock. The free name is looked up while writers
+// keep appending to the current file; the write lock is only taken to close,
+// rename and reopen it.
 func (w *FileBackend) doRotate(logTime time.Time) error {
 	_, err := os.Lstat(w.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		// there is nothing to rename, start the file again
+		w.Lock()
+		_, err = w.reopenIfRemoved()
+		w.Unlock()
+		return err
+	}
 	if err != nil {
 		return err
 	}
@@ -252,18 +1487,29 @@ func (w *FileBackend) doRotate(logTime time.Time) error {
 	// Find the next available number
 	num := 1
 	fName := ""
-	modTime := logTime
-	if w.Daily && logTime.Day() != w.dailyOpenDate {
-		info, err := os.Lstat(w.Filename)
-		if err != nil {
-			return fmt.Errorf("Rotate: Cannot find free log number to rename %s\n", w.Filename)
-		}
-		modTime = info.ModTime()
+	stamp := logTime.Format("2006-01-02")
+	w.optLock.RLock()
+	daily := w.Daily
+	w.optLock.RUnlock()
+	openDate := int(w.dailyOpenDate.Load())
+	if daily && dateOf(logTime) != openDate && w.SkipEmptyRotation && w.maxSizeCurSize.Load() == 0 {
+		// nothing to rename, the file now belongs to the new day
+		w.dailyOpenDate.Store(int64(dateOf(logTime)))
+		return nil
+	}
+	if daily && dateOf(logTime) != openDate {
+		// named after the day it was written, which is not the day before
+		// logTime if the clock jumped
+		stamp = fmt.Sprintf("%04d-%02d-%02d", openDate/10000, openDate/100%100, openDate%100)
 	}
 
-	for ; err == nil && num <= maxFileIndex; num++ {
-		fName = w.fileNameOnly + fmt.Sprintf(".%s.%03d%s", modTime.Format("2006-01-02"), num, w.suffix)
+	width, maxIndex := w.indexWidth()
+	for ; err == nil && num <= maxIndex; num++ {
+		fName = w.fileNameOnly + fmt.Sprintf(".%s.%0*d%s", stamp, width, num, w.suffix)
 		_, err = os.Lstat(fName)
+		if err != nil && w.Compressor != nil {
+			_, err = os.Lstat(fName + w.Compressor.Extension())
+		}
 	}
 
 	// return error if the last file checked still existed
@@ -271,41 +1517,251 @@ func (w *FileBackend) doRotate(logTime time.Time) error {
 		return fmt.Errorf("Rotate: Cannot find free log number to rename %s\n", w.Filename)
 	}
 
-	// close fileWriter before rename
-	w.fileWriter.Close()
+	w.Lock()
+	if w.fileClosed {
+		// closed by CloseWithTimeout meanwhile
+		w.Unlock()
+		return nil
+	}
+	// close fileWriter before rename, it stays nil until reopened so that
+	// no write can reach the closed file if reopening fails
+	if w.fileWriter != nil {
+		w.writeFooter()
+		w.releasePreallocated()
+		w.fileWriter.Close()
+		w.fileWriter = nil
+	}
 
 	// Rename the file to its new found name
 	// even if occurs error,we MUST guarantee to  restart new logger
 	renameErr := os.Rename(w.Filename, fName)
-	// re-start logger
-	startLoggerErr := w.startLogger()
-	go w.deleteOldLog()
+	// reopen the file, the writer goroutine keeps running
+	openErr := w.openFile()
+	w.Unlock()
+	w.requestMaintenance()
 
-	if startLoggerErr != nil {
-		return fmt.Errorf("Rotate StartLogger: %s\n", startLoggerErr)
+	if openErr != nil {
+		return fmt.Errorf("Rotate: cannot reopen the file: %s\n", openErr)
 	}
 	if renameErr != nil {
 		return fmt.Errorf("Rotate: %s\n", renameErr)
 	}
+	w.notifyRotate(fName, w.Filename)
+	select {
+	case w.events() <- RotateEvent{OldPath: fName, NewPath: w.Filename, Time: time.Now()}:
+	default:
+	}
 	return nil
 
 }
 
-func (w *FileBackend) deleteOldLog() {
-	dir := filepath.Dir(w.Filename)
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) (returnErr error) {
+// footer returns what Footer returns, nil if it is not set.
+func (w *FileBackend) footer() []byte {
+	if w.Footer == nil {
+		return nil
+	}
+	return w.Footer(w)
+}
+
+// writeFooter writes the footer as the end of the file, before it is closed.
+// The caller must hold the write lock.
+func (w *FileBackend) writeFooter() {
+	if w.fileWriter == nil {
+		return
+	}
+	footer := w.footer()
+	if len(footer) == 0 {
+		return
+	}
+	if _, err := w.writeFile(footer); err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): footer: %s\n", w.Filename, err)
+	}
+}
+
+// RotateEvents returns a channel receiving an event after each successful
+// rotation. Logging never waits for the reader; events are dropped while
+// rotateEventsLen of them are waiting. The channel is closed by Close.
+func (w *FileBackend) RotateEvents() <-chan RotateEvent {
+	return w.events()
+}
+
+func (w *FileBackend) events() chan RotateEvent {
+	w.eventsOnce.Do(func() {
+		w.rotateEvents = make(chan RotateEvent, rotateEventsLen)
+	})
+	return w.rotateEvents
+}
+
+func (w *FileBackend) notifyRotate(oldPath, newPath string) {
+	if w.OnRotate == nil {
+		return
+	}
+	call := func() {
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Fprintf(os.Stderr, "Unable to delete old log '%s', error: %v\n", path, r)
+				fmt.Fprintf(os.Stderr, "FileLogWriter(%q): OnRotate panic: %v\n", w.Filename, r)
 			}
 		}()
+		w.OnRotate(oldPath, newPath)
+	}
+	if w.OnRotateAsync {
+		go call()
+	} else {
+		call()
+	}
+}
+
+// requestMaintenance asks the maintenance worker, started on first use, for a
+// pass. It does not block; a request is dropped if one is already pending.
+func (w *FileBackend) requestMaintenance() {
+	w.maintainOnce.Do(func() {
+		w.maintainChan = make(chan struct{}, 1)
+		w.maintainWg.Add(1)
+		go func() {
+			defer w.maintainWg.Done()
+			for range w.maintainChan {
+				w.maintain()
+			}
+		}()
+	})
+	select {
+	case w.maintainChan <- struct{}{}:
+	default:
+	}
+}
+
+// maintain compresses the rotated files, then deletes the old ones.
+func (w *FileBackend) maintain() {
+	if w.Compressor != nil {
+		w.compressBackups()
+	}
+	w.deleteOldLog()
+}
+
+// backupStampRegexp matches the part doRotate puts between fileNameOnly and
+// suffix, like "2013-01-01.001".
+var backupStampRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}\.\d+$`)
+
+// isBackup reports whether name is the base name of a rotated, uncompressed file.
+func (w *FileBackend) isBackup(name string) bool {
+	prefix := filepath.Base(w.fileNameOnly) + "."
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, w.suffix) ||
+		len(name) < len(prefix)+len(w.suffix) {
+		return false
+	}
+	return backupStampRegexp.MatchString(name[len(prefix) : len(name)-len(w.suffix)])
+}
+
+// CurrentFile returns the path of the file being written to.
+func (w *FileBackend) CurrentFile() string {
+	w.optLock.RLock()
+	defer w.optLock.RUnlock()
+	return w.Filename
+}
+
+// CurrentLines returns the number of lines of the current file, as counted
+// for MaxLines.
+func (w *FileBackend) CurrentLines() int {
+	return int(w.maxLinesCurLines.Load())
+}
+
+// CurrentSize returns the size of the current file, as counted for MaxSize.
+func (w *FileBackend) CurrentSize() int64 {
+	return w.maxSizeCurSize.Load()
+}
+
+// BackupFiles returns the paths of the rotated files, compressed or not,
+// oldest first.
+func (w *FileBackend) BackupFiles() ([]string, error) {
+	if w.sink != nil {
+		return nil, nil
+	}
+	dir := filepath.Dir(w.Filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	last := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if w.Compressor != nil {
+			name = strings.TrimSuffix(name, w.Compressor.Extension())
+		}
+		if !entry.Type().IsRegular() || !w.isBackup(name) {
+			continue
+		}
+		// sorted by name, which is oldest first; a file being compressed
+		// is listed once, the compressed copy sorting right after it
+		path := filepath.Join(dir, entry.Name())
+		if name == last {
+			files[len(files)-1] = path
+			continue
+		}
+		files = append(files, path)
+		last = name
+	}
+	return files, nil
+}
 
-		if !info.IsDir() && info.ModTime().Unix() < (time.Now().Unix()-60*60*24*w.MaxDays) {
-			if strings.HasPrefix(filepath.Base(path), w.fileNameOnly) &&
-				strings.HasSuffix(filepath.Base(path), w.suffix) {
-				os.Remove(path)
+func (w *FileBackend) compressBackups() {
+	dir := filepath.Dir(w.Filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
+		return
+	}
+	// sorted by name, which is oldest first
+	var backups []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && w.isBackup(entry.Name()) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) <= w.CompressAfter {
+		return
+	}
+	ext := w.Compressor.Extension()
+	for _, name := range backups[:len(backups)-w.CompressAfter] {
+		src := filepath.Join(dir, name)
+		if w.CompressMinSize > 0 {
+			info, err := os.Lstat(src)
+			if err != nil || info.Size() < w.CompressMinSize {
+				continue
 			}
 		}
+		tmp := src + ext + ".tmp"
+		err := w.Compressor.Compress(src, tmp)
+		if err == nil {
+			err = os.Rename(tmp, src+ext)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			fmt.Fprintf(os.Stderr, "FileLogWriter(%q): compress %s: %s\n", w.Filename, src, err)
+			continue
+		}
+		os.Remove(src)
+	}
+}
+
+// deleteOldLog deletes the rotated files older than MaxDays days.
+func (w *FileBackend) deleteOldLog() {
+	if w.MaxDays <= 0 {
 		return
-	})
+	}
+	files, err := w.BackupFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
+		return
+	}
+	cutoff := time.Now().Add(-24 * time.Hour * time.Duration(w.MaxDays))
+	for _, path := range files {
+		info, err := os.Lstat(path)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to delete old log '%s', error: %v\n", path, err)
+		}
+	}
 }
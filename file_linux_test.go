@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openFlags returns the flags f was opened with.
+func openFlags(t *testing.T, f *os.File) int {
+	info, err := os.ReadFile("/proc/self/fdinfo/" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(info), "\n") {
+		if value, ok := strings.CutPrefix(line, "flags:"); ok {
+			flags, err := strconv.ParseInt(strings.TrimSpace(value), 8, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return int(flags)
+		}
+	}
+	t.Fatalf("no flags in %q", info)
+	return 0
+}
+
+func TestFileSyncWrites(t *testing.T) {
+	for _, syncWrites := range []bool{false, true} {
+		filename := filepath.Join(t.TempDir(), "sync.log")
+		fileBackend := NewFileBackend(filename)
+		fileBackend.SyncWrites = syncWrites
+		if err := fileBackend.Start(); err != nil {
+			t.Fatal(err)
+		}
+		flags := openFlags(t, fileBackend.fileWriter.(*os.File))
+		assert.Equal(t, syncWrites, flags&syscall.O_SYNC == syscall.O_SYNC)
+
+		// written through without calling Sync
+		fileBackend.Write([]byte("durable"))
+		content, err := os.ReadFile(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, "durable\n", string(content))
+		fileBackend.Close()
+	}
+}
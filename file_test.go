@@ -2,10 +2,21 @@ package logging
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -13,6 +24,7 @@ import (
 )
 
 func TestFile1(t *testing.T) {
+	Reset()
 	log := NewLogger("TestFile1")
 	fileBackend, err := NewDefaultFileBackend("test.log")
 	if err != nil {
@@ -45,7 +57,7 @@ func TestFile1(t *testing.T) {
 	}
 	f.Close()
 	fileBackend.Close()
-	var expected = int(DEBUG) + 1
+	var expected = 6
 	if lineNum != expected {
 		t.Fatal(lineNum, "not "+strconv.Itoa(expected)+" lines")
 	}
@@ -53,6 +65,7 @@ func TestFile1(t *testing.T) {
 }
 
 func TestFile2(t *testing.T) {
+	Reset()
 	log := NewLogger("TestFile2")
 	fileBackend, err := NewDefaultFileBackend("test2.log", 1000)
 	if err != nil {
@@ -85,7 +98,7 @@ func TestFile2(t *testing.T) {
 		}
 	}
 	f.Close()
-	var expected = int(DEBUG) + 1
+	var expected = 6
 	if lineNum != expected {
 		t.Fatal(lineNum, "not "+strconv.Itoa(expected)+" lines")
 	}
@@ -93,6 +106,7 @@ func TestFile2(t *testing.T) {
 }
 
 func TestFileRotate(t *testing.T) {
+	Reset()
 	log := NewLogger("TestFileRotate")
 	fileBackend, err := NewDefaultFileBackend("test3.log")
 	if err != nil {
@@ -117,6 +131,1600 @@ func TestFileRotate(t *testing.T) {
 	os.Remove("test3.log")
 }
 
+func TestFileCloseWithTimeout(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "close.log")
+	fileBackend, err := NewDefaultFileBackend(filename, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger("TestFileCloseWithTimeout")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	for i := 0; i < 10; i++ {
+		log.Info("line", i)
+	}
+	if err := fileBackend.CloseWithTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, bytes.Count(b, []byte{'\n'}))
+	// closing again is a no-op
+	assert.NoError(t, fileBackend.CloseWithTimeout(time.Second))
+}
+
+// stallWriter blocks its first write until release is closed.
+type stallWriter struct {
+	bytes.Buffer
+	stalled, release chan struct{}
+	once             sync.Once
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.stalled)
+		<-w.release
+	})
+	return w.Buffer.Write(p)
+}
+
+func TestFileCloseWithTimeoutExpired(t *testing.T) {
+	Reset()
+	// a stalled disk
+	sink := &stallWriter{stalled: make(chan struct{}), release: make(chan struct{})}
+	fileBackend := NewFileBackend("")
+	fileBackend.sink = sink
+	fileBackend.Rotate = false
+	fileBackend.BufferSize = 4
+	if err := fileBackend.Start(100); err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger("TestFileCloseWithTimeoutExpired")
+	log.SetBackend(AddModuleLevel(fileBackend))
+
+	for i := 0; i < 10; i++ {
+		log.Info("line", i)
+	}
+	<-sink.stalled
+	time.AfterFunc(100*time.Millisecond, func() { close(sink.release) })
+	err := fileBackend.CloseWithTimeout(50 * time.Millisecond)
+	if !errors.Is(err, ErrCloseTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	assert.Contains(t, err.Error(), "9 messages not written")
+	// the write in progress was waited for, nothing is written after it
+	written := sink.String()
+	assert.Equal(t, 1, strings.Count(written, "line 0"))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, written, sink.String())
+}
+
+func TestFileRotateAsynchronousGoroutines(t *testing.T) {
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "goroutines.log"))
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 1
+	if err := fileBackend.Start(10); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	drained := func() {
+		for i := 0; i < 1000 && len(fileBackend.asyncMsgChan) > 0; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		// the last message is taken, let it be written
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the first rotation starts the maintenance goroutine
+	fileBackend.Write([]byte("first"))
+	fileBackend.Write([]byte("second"))
+	drained()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		fileBackend.Write([]byte(strconv.Itoa(i)))
+	}
+	drained()
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	files, err := fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 101)
+}
+
+func TestFileAudit(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.log")
+	auditPath := filepath.Join(dir, "audit.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 1
+	fileBackend.AuditPath = auditPath
+	fileBackend.AuditMinLevel = WARNING
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger("TestFileAudit")
+	leveled := AddModuleLevel(fileBackend)
+	leveled.SetLevel(DEBUG, "")
+	log.SetBackend(leveled)
+	log.Info("info")
+	log.Warning("warning")
+	log.Debug("debug")
+	// not written to the main file, so not audited either
+	msg := "canceled"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := &Record{Time: time.Now(), Level: ERROR, message: &msg, formatter: MustStringFormatter("%{message}")}
+	assert.Equal(t, context.Canceled, fileBackend.LogContext(ctx, 0, rec))
+	log.Error("error")
+	fileBackend.Close()
+
+	// the main file rotated but the audit file did not
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "error\n", string(content))
+	content, err = os.ReadFile(auditPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "warning\nerror\n", string(content))
+
+	// a failing audit file does not stop the main file
+	fileBackend = NewFileBackend(filename)
+	fileBackend.Rotate = false
+	fileBackend.AuditPath = "/dev/full"
+	fileBackend.AuditMinLevel = WARNING
+	var errs []error
+	fileBackend.ErrorHandler = func(err error) { errs = append(errs, err) }
+	if err := fileBackend.Start(); err != nil {
+		t.Skip(err)
+	}
+	log.SetBackend(AddModuleLevel(fileBackend))
+	log.Error("written")
+	fileBackend.Close()
+	assert.Len(t, errs, 1)
+	content, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "error\nwritten\n", string(content))
+}
+
+func TestFileSyncInterval(t *testing.T) {
+	Reset()
+	filename := filepath.Join(t.TempDir(), "sync.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.SyncInterval = 5 * time.Millisecond
+	before := runtime.NumGoroutine()
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, fileBackend.Start(), "second Start must fail")
+	assert.Greater(t, runtime.NumGoroutine(), before)
+
+	synced := &syncCountFile{File: fileBackend.fileWriter.(*os.File)}
+	fileBackend.Lock()
+	fileBackend.fileWriter = synced
+	fileBackend.Unlock()
+
+	log := NewLogger("TestFileSyncInterval")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	log.Info("synced")
+	for i := 0; i < 100 && synced.syncs.Load() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.NotZero(t, synced.syncs.Load(), "not synced before Close")
+	fileBackend.Close()
+
+	// the sync goroutine exits on Close
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	b, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "synced\n", string(b))
+}
+
+func TestFileSyncIntervalErrorHandler(t *testing.T) {
+	fileBackend := NewFileBackend(filepath.Join(t.TempDir(), "sync.log"))
+	fileBackend.SyncInterval = time.Millisecond
+	errs := make(chan error, 1)
+	fileBackend.ErrorHandler = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Lock()
+	file := fileBackend.fileWriter
+	fileBackend.fileWriter = failSyncFile{file.(*os.File)}
+	fileBackend.Unlock()
+
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "sync failed")
+	case <-time.After(time.Second):
+		t.Error("the sync error did not reach ErrorHandler")
+	}
+	fileBackend.Lock()
+	fileBackend.fileWriter = file
+	fileBackend.Unlock()
+	fileBackend.Close()
+}
+
+type failSyncFile struct {
+	*os.File
+}
+
+func (f failSyncFile) Sync() error {
+	return errors.New("sync failed")
+}
+
+type syncCountFile struct {
+	*os.File
+	syncs atomic.Int64
+}
+
+func (f *syncCountFile) Sync() error {
+	f.syncs.Add(1)
+	return f.File.Sync()
+}
+
+func TestFileCountLinesOnInit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "count.log")
+	if err := os.WriteFile(filename, []byte("1\n2\n3\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		count    bool
+		maxLines int
+		expected int
+	}{
+		{"count", true, 100, 3},
+		{"skip", false, 100, 0},
+		{"no line rotation", true, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileBackend := NewFileBackend(filename)
+			fileBackend.CountLinesOnInit = tt.count
+			fileBackend.MaxLines = tt.maxLines
+			if err := fileBackend.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer fileBackend.Close()
+			assert.EqualValues(t, tt.expected, fileBackend.maxLinesCurLines.Load())
+			assert.EqualValues(t, 6, fileBackend.maxSizeCurSize.Load())
+		})
+	}
+}
+
+func TestFileWrite(t *testing.T) {
+	dir := t.TempDir()
+	fileBackend, err := NewDefaultFileBackend(filepath.Join(dir, "write.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.SetMaxLines(2)
+	std := log.New(fileBackend, "std: ", 0)
+	std.Print("one")
+	std.Print("two")
+	std.Print("three")
+	n, err := fileBackend.Write([]byte("four"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	fileBackend.Close()
+
+	_, err = fileBackend.Write([]byte("closed"))
+	assert.Equal(t, ErrBackendClosed, err)
+
+	b, err := os.ReadFile(filepath.Join(dir, "write.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "std: three\nfour\n", string(b))
+	rotated, _ := filepath.Glob(filepath.Join(dir, "write.*.001.log"))
+	assert.Len(t, rotated, 1)
+}
+
+func TestFileWriteAsynchronous(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "write.log")
+	fileBackend, err := NewDefaultFileBackend(filename, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := []byte("reused")
+	fileBackend.Write(p)
+	copy(p, "XXXXXX")
+	fileBackend.Write([]byte("second\n"))
+	fileBackend.Close()
+
+	b, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "reused\nsecond\n", string(b))
+}
+
+func TestFileMaxLinesAsynchronous(t *testing.T) {
+	dir := t.TempDir()
+	fileBackend, err := NewDefaultFileBackend(filepath.Join(dir, "lines.log"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.SetMaxLines(5)
+	for i := 0; i < 23; i++ {
+		fileBackend.Write([]byte(strconv.Itoa(i)))
+	}
+	fileBackend.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "lines.*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	files = append(files, filepath.Join(dir, "lines.log"))
+	var counts []int
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, bytes.Count(content, []byte{'\n'}))
+	}
+	assert.Equal(t, []int{5, 5, 5, 5, 3}, counts)
+}
+
+// countFile counts the writes to the file.
+type countFile struct {
+	*os.File
+	writes int
+}
+
+func (f *countFile) Write(p []byte) (int, error) {
+	f.writes++
+	return f.File.Write(p)
+}
+
+func TestFileBatch(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "batch.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.BatchSize = 8
+	// never reached: each batch is full, or completed by Close
+	fileBackend.BatchTimeout = time.Minute
+	fileBackend.MaxLines = 5
+	if err := fileBackend.Start(64); err != nil {
+		t.Fatal(err)
+	}
+	counter := &countFile{File: fileBackend.fileWriter.(*os.File)}
+	fileBackend.Lock()
+	fileBackend.fileWriter = counter
+	fileBackend.Unlock()
+
+	for i := 0; i < 23; i++ {
+		fileBackend.Write([]byte(strconv.Itoa(i)))
+	}
+	fileBackend.Close()
+
+	// the first file got its 5 lines in a single write, the rest of the
+	// batch went to the next file
+	assert.Equal(t, 1, counter.writes)
+	files, err := filepath.Glob(filepath.Join(dir, "batch.*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	files = append(files, filename)
+	var content []string
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, string(b))
+	}
+	assert.Equal(t, []string{"0\n1\n2\n3\n4\n", "5\n6\n7\n8\n9\n", "10\n11\n12\n13\n14\n",
+		"15\n16\n17\n18\n19\n", "20\n21\n22\n"}, content)
+}
+
+func TestFileBatchNoRotation(t *testing.T) {
+	dir := t.TempDir()
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+
+	// a file that is not rotated, and a writer that never is
+	filename := filepath.Join(dir, "norotate.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Rotate = false
+	sink := &countWriter{}
+	writerBackend := NewFileBackend("")
+	writerBackend.sink = sink
+	for _, backend := range []*FileBackend{fileBackend, writerBackend} {
+		backend.BatchSize = 64
+		backend.BatchTimeout = time.Minute
+		backend.MaxLines = 5
+		if err := backend.Start(64); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileBackend.Lock()
+	counter := &countFile{File: fileBackend.fileWriter.(*os.File)}
+	fileBackend.fileWriter = counter
+	fileBackend.Unlock()
+	// the day changed since the files were opened
+	timeNow = func() time.Time { return time.Now().Add(24 * time.Hour) }
+
+	for i := 0; i < 64; i++ {
+		fileBackend.Write([]byte(strconv.Itoa(i)))
+		writerBackend.Write([]byte(strconv.Itoa(i)))
+	}
+	fileBackend.Close()
+	writerBackend.Close()
+
+	// one write for each whole batch
+	assert.Equal(t, 1, counter.writes)
+	assert.Equal(t, 1, sink.writes)
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, bytes.Count(content, []byte{'\n'}))
+	assert.Equal(t, 64, bytes.Count(sink.Bytes(), []byte{'\n'}))
+}
+
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestFileOnRotate(t *testing.T) {
+	Reset()
+	filename := filepath.Join(t.TempDir(), "hook.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.MaxLines = 2
+	var rotated [][2]string
+	fileBackend.OnRotate = func(oldPath, newPath string) {
+		rotated = append(rotated, [2]string{oldPath, newPath})
+		if len(rotated) == 1 {
+			panic("must not break logging")
+		}
+	}
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	log := NewLogger("TestFileOnRotate")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	for i := 0; i < 5; i++ {
+		log.Info("line", i)
+	}
+
+	date := time.Now().Format("2006-01-02")
+	assert.Equal(t, [][2]string{
+		{filepath.Join(filepath.Dir(filename), "hook."+date+".001.log"), filename},
+		{filepath.Join(filepath.Dir(filename), "hook."+date+".002.log"), filename},
+	}, rotated)
+	for _, r := range rotated {
+		ok, _ := exists(r[0])
+		assert.True(t, ok, r[0])
+	}
+}
+
+func TestFileLineSeparator(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "crlf.log")
+	open := func() *FileBackend {
+		fileBackend := NewFileBackend(filename)
+		fileBackend.LineSeparator = []byte("\r\n")
+		if err := fileBackend.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return fileBackend
+	}
+	fileBackend := open()
+	fileBackend.Write([]byte("one"))
+	fileBackend.Write([]byte("two\r\n"))
+	fileBackend.Write([]byte("three\n"))
+	fileBackend.Close()
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "one\r\ntwo\r\nthree\n\r\n", string(content))
+
+	fileBackend = open()
+	defer fileBackend.Close()
+	assert.EqualValues(t, 3, fileBackend.maxLinesCurLines.Load())
+
+	// a separator split between two reads is counted once
+	long := append(bytes.Repeat([]byte{'x'}, 32767), "\r\ny\r\n"...)
+	if err := os.WriteFile(filename, long, 0600); err != nil {
+		t.Fatal(err)
+	}
+	count, err := fileBackend.lines()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	for _, size := range []int{1, 3, 4096} {
+		fileBackend.CountBufferSize = size
+		count, err := fileBackend.lines()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count, size)
+	}
+}
+
+func TestFileFallback(t *testing.T) {
+	full, err := os.OpenFile("/dev/full", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("no /dev/full to simulate a full disk:", err)
+	}
+	filename := filepath.Join(t.TempDir(), "full.log")
+	fallback := &bytes.Buffer{}
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Fallback = fallback
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+
+	fileBackend.Write([]byte("kept"))
+	fileBackend.Lock()
+	file := fileBackend.fileWriter
+	fileBackend.fileWriter = full
+	fileBackend.Unlock()
+	_, err = fileBackend.Write([]byte("one"))
+	assert.Error(t, err)
+	fileBackend.Write([]byte("two"))
+	fileBackend.Lock()
+	fileBackend.fileWriter = file
+	fileBackend.Unlock()
+	fileBackend.Write([]byte("three"))
+	fileBackend.Write([]byte("four"))
+	full.Close()
+
+	assert.Equal(t, fmt.Sprintf("FileLogWriter(%q): write /dev/full: no space left on device, writing here until the file is writable\n"+
+		"one\ntwo\n"+
+		"FileLogWriter(%q): writing to the file again\n", filename, filename), fallback.String())
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "kept\nthree\nfour\n", string(content))
+}
+
+func TestFileTruncate(t *testing.T) {
+	Reset()
+	filename := filepath.Join(t.TempDir(), "fresh.log")
+	if err := os.WriteFile(filename, []byte("old\nlines\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Truncate = true
+	fileBackend.MaxLines = 2
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	assert.EqualValues(t, 0, fileBackend.maxLinesCurLines.Load())
+
+	for _, line := range []string{"one", "two", "three"} {
+		fileBackend.Write([]byte(line))
+	}
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	// rotation is unaffected
+	assert.Equal(t, "three\n", string(content))
+	backup := fileBackend.fileNameOnly + "." + time.Now().Format("2006-01-02") + ".001.log"
+	content, err = os.ReadFile(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(content))
+}
+
+func TestFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	link := filepath.Join(dir, "current.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Symlink = link
+	fileBackend.MaxLines = 1
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+
+	for _, line := range []string{"one", "two"} {
+		fileBackend.Write([]byte(line))
+		target, err := os.Readlink(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, filename, target)
+	}
+	content, err := os.ReadFile(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "two\n", string(content))
+	ok, _ := exists(link + ".tmp")
+	assert.False(t, ok)
+}
+
+func TestFileRotateAsynchronousFull(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "full.log")
+	fileBackend, err := NewDefaultFileBackend(filename, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.SetMaxLines(10)
+
+	// stall the writer so that the channel fills up and the loggers block
+	fileBackend.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(fileBackend, "%d-%d", i, j)
+			}
+		}(i)
+	}
+	for len(fileBackend.asyncMsgChan) < cap(fileBackend.asyncMsgChan) {
+		time.Sleep(time.Millisecond)
+	}
+	fileBackend.Unlock()
+	wg.Wait()
+
+	// the state left behind when the file could not be reopened
+	fileBackend.Lock()
+	fileBackend.fileWriter.Close()
+	fileBackend.fileWriter = nil
+	fileBackend.Unlock()
+	fmt.Fprint(fileBackend, "reopened")
+	assert.NoError(t, fileBackend.CloseWithTimeout(5*time.Second))
+
+	files, err := filepath.Glob(filepath.Join(dir, "full*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Greater(t, len(files), 1)
+	lines := map[string]bool{}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			lines[line] = true
+		}
+	}
+	assert.Len(t, lines, 201)
+	assert.True(t, lines["reopened"])
+}
+
+func TestFileLogContext(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ctx.log")
+	fileBackend, err := NewDefaultFileBackend(filename, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	msg := "saturated"
+	rec := &Record{
+		Time:      time.Now(),
+		Level:     INFO,
+		message:   &msg,
+		formatter: MustStringFormatter("%{message}"),
+	}
+
+	// stall the writer, one message is taken by it and one fills the channel
+	fileBackend.Lock()
+	assert.NoError(t, fileBackend.LogContext(context.Background(), 0, rec))
+	for len(fileBackend.asyncMsgChan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, fileBackend.LogContext(context.Background(), 0, rec))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, fileBackend.LogContext(ctx, 0, rec))
+	fileBackend.Unlock()
+
+	fileBackend.Close()
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "saturated\nsaturated\n", string(content))
+	assert.Equal(t, ErrBackendClosed, fileBackend.LogContext(context.Background(), 0, rec))
+}
+
+func TestFileAppendNewline(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "raw.log")
+	open := func() *FileBackend {
+		fileBackend := NewFileBackend(filename)
+		fileBackend.AppendNewline = false
+		fileBackend.LineSeparator = []byte("\r\n")
+		if err := fileBackend.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return fileBackend
+	}
+	fileBackend := open()
+	SetBackend(NewBackendFormatter(fileBackend, MustStringFormatter("%{message}\r\n")))
+	log := NewLogger("TestFileAppendNewline")
+	log.Info("formatted")
+	fileBackend.Write([]byte("par"))
+	fileBackend.Write([]byte("tial\r\n"))
+	fileBackend.Write([]byte("a\r\nb\r\n"))
+	// counted by separators, as when the file is opened again
+	assert.EqualValues(t, 4, fileBackend.maxLinesCurLines.Load())
+	fileBackend.Close()
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "formatted\r\npartial\r\na\r\nb\r\n", string(content))
+
+	fileBackend = open()
+	defer fileBackend.Close()
+	assert.EqualValues(t, 4, fileBackend.maxLinesCurLines.Load())
+}
+
+func TestFileIsHealthy(t *testing.T) {
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "health.log"))
+	fileBackend.AsyncHighWater = 2
+	if err := fileBackend.Start(8); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	assert.NoError(t, fileBackend.IsHealthy())
+
+	// backed up
+	fileBackend.Lock()
+	for i := 0; i < 4; i++ {
+		fileBackend.Write([]byte("queued"))
+	}
+	for len(fileBackend.asyncMsgChan) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	fileBackend.Unlock()
+	assert.Error(t, fileBackend.IsHealthy())
+	for len(fileBackend.asyncMsgChan) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, fileBackend.IsHealthy())
+
+	// failed write, healthy again after a successful one
+	filename := filepath.Join(dir, "sync.log")
+	syncBackend := NewFileBackend(filename)
+	syncBackend.Fallback = io.Discard
+	if err := syncBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	syncBackend.Lock()
+	file := syncBackend.fileWriter
+	syncBackend.fileWriter = readOnly
+	syncBackend.Unlock()
+	syncBackend.Write([]byte("lost"))
+	assert.Error(t, syncBackend.IsHealthy())
+	syncBackend.Lock()
+	syncBackend.fileWriter = file
+	syncBackend.Unlock()
+	syncBackend.Write([]byte("written"))
+	assert.NoError(t, syncBackend.IsHealthy())
+
+	syncBackend.Close()
+	assert.Equal(t, ErrBackendClosed, syncBackend.IsHealthy())
+}
+
+// flakyFile fails the given number of writes with err.
+type flakyFile struct {
+	*os.File
+	failures int
+	err      error
+}
+
+func (f *flakyFile) Write(p []byte) (int, error) {
+	if f.failures > 0 {
+		f.failures--
+		return 0, f.err
+	}
+	return f.File.Write(p)
+}
+
+func TestFileWriteRetries(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "retry.log")
+	fileBackend := NewFileBackend(filename)
+	var handled []error
+	fileBackend.ErrorHandler = func(err error) {
+		handled = append(handled, err)
+	}
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	// no retries by default
+	fileBackend.Lock()
+	flaky := &flakyFile{File: fileBackend.fileWriter.(*os.File), failures: 1, err: syscall.EIO}
+	fileBackend.fileWriter = flaky
+	fileBackend.Unlock()
+	_, err := fileBackend.Write([]byte("lost"))
+	assert.Equal(t, syscall.EIO, err)
+
+	// read by the writes, under the write lock
+	fileBackend.Lock()
+	fileBackend.WriteRetries = 2
+	fileBackend.RetryBackoff = time.Millisecond
+	flaky.failures = 2
+	fileBackend.Unlock()
+	_, err = fileBackend.Write([]byte("retried"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, flaky.failures)
+
+	// not worth retrying
+	flaky.failures, flaky.err = 2, syscall.EBADF
+	_, err = fileBackend.Write([]byte("bad"))
+	assert.Equal(t, syscall.EBADF, err)
+	assert.Equal(t, 1, flaky.failures)
+
+	assert.Equal(t, []error{syscall.EIO, syscall.EBADF}, handled)
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "retried\n", string(content))
+}
+
+// shortFile writes at most max bytes at a time, and fails once limit bytes
+// were written if limit > 0.
+type shortFile struct {
+	*os.File
+	max, limit, written int
+}
+
+func (f *shortFile) Write(p []byte) (int, error) {
+	if f.limit > 0 && f.written >= f.limit {
+		return 0, syscall.EIO
+	}
+	if len(p) > f.max {
+		p = p[:f.max]
+	}
+	n, err := f.File.Write(p)
+	f.written += n
+	return n, err
+}
+
+func TestFileShortWrites(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short.log")
+	fileBackend := NewFileBackend(filename)
+	var handled []error
+	fileBackend.ErrorHandler = func(err error) {
+		handled = append(handled, err)
+	}
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.Lock()
+	short := &shortFile{File: fileBackend.fileWriter.(*os.File), max: 3}
+	fileBackend.fileWriter = short
+	fileBackend.Unlock()
+
+	_, err := fileBackend.Write([]byte("a long message"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, fileBackend.maxLinesCurLines.Load())
+	assert.EqualValues(t, 15, fileBackend.maxSizeCurSize.Load())
+
+	// only the bytes written are counted
+	short.limit = short.written + 6
+	_, err = fileBackend.Write([]byte("cut\nshort"))
+	assert.ErrorIs(t, err, syscall.EIO)
+	assert.EqualError(t, err, "wrote 6 of 10 bytes: input/output error")
+	assert.Equal(t, []error{err}, handled)
+	assert.EqualValues(t, 2, fileBackend.maxLinesCurLines.Load())
+	assert.EqualValues(t, 21, fileBackend.maxSizeCurSize.Load())
+
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "a long message\ncut\nsh", string(content))
+}
+
+func TestFileEmitWriteErrorsToLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lost.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.EmitWriteErrorsToLog = true
+	var handled []error
+	fileBackend.ErrorHandler = func(err error) {
+		handled = append(handled, err)
+	}
+	if err := fileBackend.Start(10); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Lock()
+	fileBackend.fileWriter = &flakyFile{File: fileBackend.fileWriter.(*os.File), failures: 2, err: syscall.EIO}
+	fileBackend.Unlock()
+	fileBackend.Write([]byte("lost"))
+	fileBackend.Write([]byte("lost too"))
+	fileBackend.Write([]byte("written"))
+	fileBackend.Write([]byte("written too"))
+	fileBackend.Close()
+
+	assert.Len(t, handled, 2)
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("FileLogWriter(%q): 2 messages of 14 bytes not written: input/output error\n", filename)+
+		"written\nwritten too\n", string(content))
+	assert.EqualValues(t, 3, fileBackend.maxLinesCurLines.Load())
+}
+
+func TestFileTeeStderr(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tee.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.TeeStderr = true
+	if err := fileBackend.Start(4); err != nil {
+		t.Fatal(err)
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = pw
+	SetBackend(NewBackendFormatter(fileBackend, MustStringFormatter("%{color}%{level}%{color:reset} %{message}")))
+	log := NewLogger("TestFileTeeStderr")
+	log.Warning("both")
+	os.Stderr = stderr
+	pw.Close()
+	fileBackend.Close()
+
+	echoed, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	// a pipe is not a terminal
+	assert.Equal(t, "WARNING both\n", string(echoed))
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "WARNING both\n", string(content))
+}
+
+func TestFileAsyncLen(t *testing.T) {
+	for _, tt := range []struct {
+		asyncLen []int
+		async    bool
+		err      string
+	}{
+		{nil, false, ""},
+		{[]int{0}, false, ""},
+		{[]int{1}, true, ""},
+		{[]int{maxAsyncLen}, true, ""},
+		{[]int{-1}, false, "FileBackend: negative asyncLen -1"},
+		{[]int{maxAsyncLen + 1}, false, "FileBackend: asyncLen 524289 is more than 524288"},
+		{[]int{1, 2}, false, "FileBackend: one asyncLen expected, got 2"},
+	} {
+		fileBackend := NewFileBackend(filepath.Join(t.TempDir(), "async.log"))
+		err := fileBackend.Start(tt.asyncLen...)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+			continue
+		}
+		assert.NoError(t, err, tt.asyncLen)
+		assert.Equal(t, tt.async, fileBackend.asyncMsgChan != nil, tt.asyncLen)
+		fileBackend.Close()
+	}
+}
+
+func TestFileFilenameValidation(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		filename string
+		err      string
+	}{
+		{dir + string(os.PathSeparator), "ends with a path separator"},
+		{dir, "is a directory"},
+		{filepath.Join(notDir, "app.log"), "cannot create the directory"},
+		{filepath.Join(dir, "nul\x00.log"), "cannot open"},
+		{filepath.Join(dir, "sub", "app.log"), ""},
+		// no directory component, in the current directory
+		{"test_nodir.log", ""},
+	}
+	before := runtime.NumGoroutine()
+	for _, tt := range tests {
+		fileBackend, err := NewDefaultFileBackend(tt.filename, 10)
+		if tt.err == "" {
+			if assert.NoError(t, err, tt.filename) {
+				fileBackend.Close()
+			}
+			continue
+		}
+		if assert.Error(t, err, tt.filename) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+		// no half built backend
+		assert.Nil(t, fileBackend, tt.filename)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	os.Remove("test_nodir.log")
+}
+
+func TestFileStartRetry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "retry.log")
+	fileBackend := NewFileBackend(filename)
+	assert.Error(t, fileBackend.Start(10))
+	assert.Nil(t, fileBackend.asyncMsgChan)
+
+	// the cause is fixed, Start works on the same backend
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileBackend.Start(10); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Write([]byte("retried"))
+	fileBackend.Close()
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "retried\n", string(content))
+}
+
+func TestFileFlushBytes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "buffered.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.BufferSize = 4096
+	fileBackend.FlushBytes = 64
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	visible := func() int {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(content)
+	}
+
+	line := []byte("123456789") // 10 bytes with the newline
+	for flush := 1; flush <= 3; flush++ {
+		for i := 0; i < 6; i++ {
+			fileBackend.Write(line)
+		}
+		// 60 bytes buffered
+		assert.Equal(t, (flush-1)*70, visible())
+		fileBackend.Write(line)
+		assert.Equal(t, flush*70, visible())
+	}
+
+	fileBackend.Write(line)
+	assert.Equal(t, 210, visible())
+	fileBackend.Close()
+	assert.Equal(t, 220, visible())
+}
+
+func TestFileMaxDays(t *testing.T) {
+	Reset()
+	for _, maxDays := range []int64{0, -1, 1} {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "days.log")
+		old := time.Now().Add(-30 * 24 * time.Hour)
+		// an old backup, and an old file that is not a backup
+		for _, name := range []string{"days.2013-01-01.001.log", "days.notes.log"} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fileBackend := NewFileBackend(filename)
+		fileBackend.MaxDays = maxDays
+		fileBackend.Daily = false
+		fileBackend.MaxLines = 1
+		if err := fileBackend.Start(); err != nil {
+			t.Fatal(err)
+		}
+		fileBackend.Write([]byte("first"))
+		fileBackend.Write([]byte("rotate"))
+		// waits for the maintenance
+		fileBackend.Close()
+
+		ok, _ := exists(filepath.Join(dir, "days.2013-01-01.001.log"))
+		assert.Equal(t, maxDays <= 0, ok, maxDays)
+		for _, name := range []string{"days.log", "days.notes.log", "days." + time.Now().Format("2006-01-02") + ".001.log"} {
+			ok, _ := exists(filepath.Join(dir, name))
+			assert.True(t, ok, name)
+		}
+	}
+}
+
+func TestWriterBackend(t *testing.T) {
+	_, err := NewWriterBackend(nil)
+	assert.Error(t, err)
+
+	for _, asyncLen := range []int{0, 10} {
+		var buf bytes.Buffer
+		backend, err := NewWriterBackend(&buf, asyncLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend.SetRotate(true)
+		backend.SetMaxLines(2)
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(backend, "line %d", i)
+		}
+		backend.Close()
+		assert.Equal(t, "line 0\nline 1\nline 2\nline 3\nline 4\n", buf.String())
+		assert.EqualValues(t, 5, backend.maxLinesCurLines.Load())
+		assert.EqualValues(t, buf.Len(), backend.maxSizeCurSize.Load())
+		files, err := backend.BackupFiles()
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+	}
+}
+
+func TestFileSkipEmptyRotation(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "empty.log"))
+	fileBackend.SkipEmptyRotation = true
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	yesterday := dateOf(time.Now().AddDate(0, 0, -1))
+
+	// the file was opened yesterday and nothing was written
+	fileBackend.dailyOpenDate.Store(int64(yesterday))
+	fileBackend.Write([]byte("today"))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+	assert.EqualValues(t, dateOf(time.Now()), fileBackend.dailyOpenDate.Load())
+
+	// a file with content is still rotated
+	fileBackend.dailyOpenDate.Store(int64(yesterday))
+	fileBackend.Write([]byte("tomorrow"))
+	entries, _ = os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+}
+
+func TestFileDailyClockJump(t *testing.T) {
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	date := func(s string) time.Time {
+		d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Add(12 * time.Hour)
+	}
+	for _, tt := range []struct{ open, jump string }{
+		{"2013-01-31", "2012-12-31"}, // back across a year, same day of month
+		{"2013-02-15", "2013-03-15"}, // forward across a month
+		{"2013-03-15", "2013-02-15"}, // back across a month
+	} {
+		dir := t.TempDir()
+		fileBackend := NewFileBackend(filepath.Join(dir, "clock.log"))
+		timeNow = func() time.Time { return date(tt.open) }
+		if err := fileBackend.Start(); err != nil {
+			t.Fatal(err)
+		}
+		fileBackend.Write([]byte("before"))
+		// no rotation at the same date
+		fileBackend.Write([]byte("before"))
+		timeNow = func() time.Time { return date(tt.jump) }
+		fileBackend.Write([]byte("after"))
+		fileBackend.Close()
+
+		files, err := fileBackend.BackupFiles()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "clock."+tt.open+".001.log")}, files, tt.jump)
+		if len(files) == 1 {
+			content, _ := os.ReadFile(files[0])
+			assert.Equal(t, "before\nbefore\n", string(content))
+		}
+	}
+}
+
+func TestFileSetPerm(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "perm.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 2
+	fileBackend.Perm = 0644
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.Write([]byte("first"))
+
+	assert.NoError(t, fileBackend.SetPerm(0600))
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// rotates into a new file
+	fileBackend.Write([]byte("second"))
+	fileBackend.Write([]byte("third"))
+	files, _ := fileBackend.BackupFiles()
+	assert.Len(t, files, 1)
+	info, err = os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileSequenceNumbers(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "seq.log"))
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 3
+	fileBackend.SequenceNumbers = true
+	if err := fileBackend.Start(10); err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger("TestFileSequenceNumbers")
+	log.SetBackend(AddModuleLevel(fileBackend))
+	for i := 0; i < 5; i++ {
+		log.Info("log")
+		fmt.Fprint(fileBackend, "write")
+	}
+	fileBackend.Close()
+
+	// numbered across the rotations
+	files, err := fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	var all []byte
+	for _, file := range append(files, fileBackend.CurrentFile()) {
+		content, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		all = append(all, content...)
+	}
+	var want strings.Builder
+	for i := 1; i <= 10; i++ {
+		msg := "log"
+		if i%2 == 0 {
+			msg = "write"
+		}
+		fmt.Fprintf(&want, "%010d %s\n", i, msg)
+	}
+	assert.Equal(t, want.String(), string(all))
+}
+
+func TestFileRotateCheckInterval(t *testing.T) {
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	var mu sync.Mutex
+	now := time.Date(2013, 1, 1, 23, 59, 59, 0, time.Local)
+	timeNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "quiet.log"))
+	fileBackend.RotateCheckInterval = time.Millisecond
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Write([]byte("yesterday"))
+
+	// midnight passes without logging
+	mu.Lock()
+	now = now.Add(time.Second)
+	mu.Unlock()
+	var files []string
+	for i := 0; i < 1000 && len(files) == 0; i++ {
+		time.Sleep(time.Millisecond)
+		files, _ = fileBackend.BackupFiles()
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "quiet.2013-01-01.001.log")}, files)
+	fileBackend.Close()
+	content, err := os.ReadFile(filepath.Join(dir, "quiet.log"))
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+}
+
+func TestFileFooter(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "footer.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 2
+	fileBackend.BufferSize = 4096
+	unlocked := 0
+	fileBackend.Footer = func(w *FileBackend) []byte {
+		// the counts cannot change meanwhile
+		if w.TryLock() {
+			unlocked++
+			w.Unlock()
+		}
+		return []byte(fmt.Sprintf("# closed %s, %d lines\n", filepath.Base(w.CurrentFile()), w.CurrentLines()))
+	}
+	if err := fileBackend.Start(4); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b", "c"} {
+		fileBackend.Write([]byte(line))
+	}
+	fileBackend.Close()
+
+	// written when rotating
+	files, err := fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	content, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\n# closed footer.log, 2 lines\n", string(content))
+	// and when closing
+	content, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "c\n# closed footer.log, 1 lines\n", string(content))
+	assert.Zero(t, unlocked, "called without the write lock")
+}
+
+func TestFileIndexWidth(t *testing.T) {
+	Reset()
+	dir := t.TempDir()
+	fileBackend := NewFileBackend(filepath.Join(dir, "wide.log"))
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 1
+	fileBackend.IndexWidth = 5
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Write([]byte("first"))
+	fileBackend.Write([]byte("second"))
+	fileBackend.Close()
+	files, err := fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "wide."+time.Now().Format("2006-01-02")+".00001.log")}, files)
+
+	// a single digit allows 9 rotated files a day
+	fileBackend = NewFileBackend(filepath.Join(dir, "narrow.log"))
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 1
+	fileBackend.IndexWidth = 1
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		fileBackend.Write([]byte("line"))
+	}
+	fileBackend.Close()
+	files, err = fileBackend.BackupFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 9)
+	assert.Equal(t, 3, fileBackend.CurrentLines())
+}
+
+func TestFileWriteRaw(t *testing.T) {
+	for _, asyncLen := range []int{0, 10} {
+		filename := filepath.Join(t.TempDir(), "raw.log")
+		fileBackend := NewFileBackend(filename)
+		fileBackend.SequenceNumbers = true
+		if err := fileBackend.Start(asyncLen); err != nil {
+			t.Fatal(err)
+		}
+		raw := []byte("\x1b[31mred\x1b[0m 100%")
+		assert.NoError(t, fileBackend.WriteRaw(raw))
+		assert.NoError(t, fileBackend.WriteRaw([]byte("line\n")))
+		// p is not retained
+		copy(raw, "xxx")
+		fileBackend.Close()
+		assert.Equal(t, ErrBackendClosed, fileBackend.WriteRaw(raw))
+
+		content, err := os.ReadFile(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, "\x1b[31mred\x1b[0m 100%\nline\n", string(content))
+		assert.Equal(t, 2, fileBackend.CurrentLines())
+		assert.EqualValues(t, len(content), fileBackend.CurrentSize())
+	}
+}
+
+func TestFileDirectoryRemoved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	filename := filepath.Join(dir, "removed.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 2
+	fileBackend.DirPerm = 0750
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.Write([]byte("kept"))
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	// written to the removed file
+	fileBackend.Write([]byte("lost"))
+	// the rotation starts a new file
+	fileBackend.Write([]byte("resumed"))
+	fileBackend.Write([]byte("again"))
+
+	info, err := os.Stat(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	}
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "resumed\nagain\n", string(content))
+	assert.Equal(t, 2, fileBackend.CurrentLines())
+}
+
+func TestFileDirectoryRemovedBetweenRotations(t *testing.T) {
+	// recreated by the sync loop
+	dir := filepath.Join(t.TempDir(), "logs")
+	filename := filepath.Join(dir, "synced.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Daily = false
+	fileBackend.SyncInterval = time.Millisecond
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.Write([]byte("kept"))
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if ok, _ := exists(filename); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fileBackend.Write([]byte("resumed"))
+	fileBackend.Close()
+	content, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "resumed\n", string(content))
+
+	// recreated when a write fails
+	filename = filepath.Join(dir, "failed.log")
+	fileBackend = NewFileBackend(filename)
+	fileBackend.Daily = false
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.Lock()
+	fileBackend.fileWriter = &flakyFile{File: fileBackend.fileWriter.(*os.File), failures: 1, err: syscall.EIO}
+	fileBackend.Unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fileBackend.Write([]byte("retried"))
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "retried\n", string(content))
+}
+
+func TestFileValidate(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "sub", "validate.log")
+	fileBackend := NewFileBackend(filename)
+	assert.NoError(t, fileBackend.Validate())
+	// nothing is created
+	_, err := os.Stat(filepath.Dir(filename))
+	assert.True(t, os.IsNotExist(err))
+
+	fileBackend.Daily = false
+	fileBackend.MaxLines = 0
+	fileBackend.MaxSize = 0
+	fileBackend.Perm = 0444
+	fileBackend.IndexWidth = 12
+	fileBackend.SyncInterval = -time.Second
+	fileBackend.AuditPath = filepath.Join(dir, "audit.log")
+	err = fileBackend.Validate()
+	if assert.Error(t, err) {
+		for _, problem := range []string{
+			"Rotate is set but MaxLines, MaxSize and Daily never rotate",
+			"Perm -r--r--r-- does not let the owner write",
+			"IndexWidth 12 is more than 9",
+			"negative SyncInterval -1s",
+			"AuditPath is set but AuditMinLevel is OFF",
+		} {
+			assert.Contains(t, err.Error(), problem)
+		}
+	}
+
+	assert.ErrorIs(t, NewFileBackend("").Validate(), errNoFilename)
+	// the parent of the log file is a file
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = NewFileBackend(filepath.Join(blocker, "x.log")).Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not a directory")
+	}
+	err = NewFileBackend(dir).Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is a directory")
+	}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestStripColors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"plain",
+		"\x1b[31mred\x1b[0m",
+		"\x1b[1;31mbold\x1b[0m",
+		"\x1b[123m\x1b[m\x1b[",
+		"a\x1b\x1b[9mb\x1b[99",
+	} {
+		expected := colorRegexp.ReplaceAllString(in, "")
+		if out := string(stripColors([]byte(in))); out != expected {
+			t.Errorf("%q: %q != %q", in, out, expected)
+		}
+	}
+}
+
+func TestFileRotateEvents(t *testing.T) {
+	Reset()
+	filename := filepath.Join(t.TempDir(), "events.log")
+	fileBackend, err := NewDefaultFileBackend(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.SetMaxLines(1)
+	events := fileBackend.RotateEvents()
+	for i := 0; i < rotateEventsLen+3; i++ {
+		fileBackend.Write([]byte("line"))
+	}
+	fileBackend.Close()
+
+	date := time.Now().Format("2006-01-02")
+	var received []RotateEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	// the reader was too slow for the last ones
+	if assert.Len(t, received, rotateEventsLen) {
+		assert.Equal(t, filepath.Join(filepath.Dir(filename), "events."+date+".001.log"), received[0].OldPath)
+		assert.Equal(t, filename, received[0].NewPath)
+		assert.WithinDuration(t, time.Now(), received[0].Time, time.Minute)
+	}
+}
+
+// TestFileSetters is meant to be run with -race.
+func TestFileSetters(t *testing.T) {
+	fileBackend, err := NewDefaultFileBackend(filepath.Join(t.TempDir(), "set.log"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				fileBackend.Write([]byte("line"))
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			fileBackend.SetMaxLines(50 + i%50)
+			fileBackend.SetMaxSize(1 << 20)
+			fileBackend.SetDaily(i%2 == 0)
+			fileBackend.SetRotate(i%10 != 0)
+		}
+	}()
+	wg.Wait()
+}
+
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -156,6 +1764,30 @@ func BenchmarkFileAsynchronous(b *testing.B) {
 	os.Remove("test4.log")
 }
 
+// BenchmarkFileLog measures the backend alone, run it with -benchmem.
+func BenchmarkFileLog(b *testing.B) {
+	fileBackend, err := NewDefaultFileBackend(filepath.Join(b.TempDir(), "alloc.log"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.SetRotate(false)
+	msg := "debug"
+	rec := &Record{
+		Time:      time.Now(),
+		Module:    "BenchmarkFileLog",
+		Level:     DEBUG,
+		message:   &msg,
+		formatter: MustStringFormatter("%{color}%{level} %{message}%{color:reset}"),
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec.formatted = ""
+		fileBackend.Log(0, rec)
+	}
+}
+
 func BenchmarkFileCallDepth(b *testing.B) {
 	log := NewLogger("BenchmarkFileCallDepth")
 	fileBackend, err := NewDefaultFileBackend("test4.log")
@@ -200,6 +1832,119 @@ func BenchmarkFileOnGoroutine(b *testing.B) {
 	os.Remove("test4.log")
 }
 
+// BenchmarkFileInitFd measures reopening a large existing file with and
+// without the initial line count.
+func BenchmarkFileInitFd(b *testing.B) {
+	filename := filepath.Join(b.TempDir(), "large.log")
+	line := []byte("a line of forty bytes padded out to size\n")
+	f, err := os.Create(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for size := 0; size < 64<<20; size += len(line) {
+		f.Write(line)
+	}
+	f.Close()
+
+	for _, count := range []bool{true, false} {
+		b.Run(fmt.Sprintf("CountLinesOnInit=%v", count), func(b *testing.B) {
+			fileBackend := NewFileBackend(filename)
+			fileBackend.CountLinesOnInit = count
+			if err := fileBackend.Start(); err != nil {
+				b.Fatal(err)
+			}
+			defer fileBackend.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := fileBackend.initFd(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFileParallel measures concurrent writers, which all read the
+// counters to check for rotation.
+func BenchmarkFileParallel(b *testing.B) {
+	fileBackend := NewFileBackend(filepath.Join(b.TempDir(), "parallel.log"))
+	fileBackend.BufferSize = 64 << 10
+	if err := fileBackend.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer fileBackend.Close()
+	line := []byte("a line of forty bytes padded out to size\n")
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fileBackend.Write(line)
+		}
+	})
+}
+
+// BenchmarkFileLines measures counting the lines of a large file with
+// several CountBufferSize.
+func BenchmarkFileLines(b *testing.B) {
+	filename := filepath.Join(b.TempDir(), "large.log")
+	line := []byte("a line of forty bytes padded out to size\n")
+	f, err := os.Create(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for size := 0; size < 64<<20; size += len(line) {
+		f.Write(line)
+	}
+	f.Close()
+
+	for _, size := range []int{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("CountBufferSize=%d", size), func(b *testing.B) {
+			fileBackend := NewFileBackend(filename)
+			fileBackend.CountBufferSize = size
+			b.SetBytes(64 << 20)
+			for i := 0; i < b.N; i++ {
+				if _, err := fileBackend.lines(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFileRotateLatency reports the tail latency of a Log call while
+// concurrent writers force frequent size based rotation.
+func BenchmarkFileRotateLatency(b *testing.B) {
+	fileBackend, err := NewDefaultFileBackend(filepath.Join(b.TempDir(), "rotate.log"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	fileBackend.SetMaxSize(1 << 16)
+	log := NewLogger("BenchmarkFileRotateLatency")
+	log.SetBackend(AddModuleLevel(fileBackend))
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var local []time.Duration
+		for pb.Next() {
+			start := time.Now()
+			log.Info("a line of forty bytes padded out to size")
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+	fileBackend.Close()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		b.ReportMetric(float64(latencies[n*99/100].Nanoseconds()), "p99-ns")
+		b.ReportMetric(float64(latencies[n*999/1000].Nanoseconds()), "p99.9-ns")
+	}
+}
+
 func TestFileBackend_doRotate(t *testing.T) {
 	assert := assert.New(t)
 	file, err := os.OpenFile("test.log", os.O_CREATE|os.O_RDWR, 0666)
@@ -326,7 +2071,7 @@ func TestFileBackend_doRotate(t *testing.T) {
 				maxSizeCurSize:   0,
 				Daily:            true,
 				MaxDays:          0,
-				dailyOpenDate:    0,
+				dailyOpenDate:    dateOf(time.Now().Add(-24 * time.Hour)),
 				Rotate:           true,
 				Perm:             0660,
 				fileNameOnly:     "test",
@@ -334,9 +2079,10 @@ func TestFileBackend_doRotate(t *testing.T) {
 				asyncMsgChan:     make(chan []byte),
 				asyncSignalChan:  make(chan struct{}),
 			},
-			newName: "test." + time.Now().Format("2006-01-02") + ".003.log",
+			// named after the day the file was opened
+			newName: "test." + time.Now().Add(-24*time.Hour).Format("2006-01-02") + ".001.log",
 			args: args{
-				logTime: time.Now().Add(24 * time.Hour),
+				logTime: time.Now(),
 			},
 			err: "",
 		},
@@ -344,23 +2090,23 @@ func TestFileBackend_doRotate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := &FileBackend{
-				status:           tt.fields.status,
-				Filename:         tt.fields.Filename,
-				fileWriter:       tt.fields.fileWriter,
-				MaxLines:         tt.fields.MaxLines,
-				maxLinesCurLines: tt.fields.maxLinesCurLines,
-				MaxSize:          tt.fields.MaxSize,
-				maxSizeCurSize:   tt.fields.maxSizeCurSize,
-				Daily:            tt.fields.Daily,
-				MaxDays:          tt.fields.MaxDays,
-				dailyOpenDate:    tt.fields.dailyOpenDate,
-				Rotate:           tt.fields.Rotate,
-				Perm:             tt.fields.Perm,
-				fileNameOnly:     tt.fields.fileNameOnly,
-				suffix:           tt.fields.suffix,
-				asyncMsgChan:     tt.fields.asyncMsgChan,
-				asyncSignalChan:  tt.fields.asyncSignalChan,
+				status:          tt.fields.status,
+				Filename:        tt.fields.Filename,
+				fileWriter:      tt.fields.fileWriter,
+				MaxLines:        tt.fields.MaxLines,
+				MaxSize:         tt.fields.MaxSize,
+				Daily:           tt.fields.Daily,
+				MaxDays:         tt.fields.MaxDays,
+				Rotate:          tt.fields.Rotate,
+				Perm:            tt.fields.Perm,
+				fileNameOnly:    tt.fields.fileNameOnly,
+				suffix:          tt.fields.suffix,
+				asyncMsgChan:    tt.fields.asyncMsgChan,
+				asyncSignalChan: tt.fields.asyncSignalChan,
 			}
+			w.maxLinesCurLines.Store(int64(tt.fields.maxLinesCurLines))
+			w.maxSizeCurSize.Store(int64(tt.fields.maxSizeCurSize))
+			w.dailyOpenDate.Store(int64(tt.fields.dailyOpenDate))
 			if err := w.doRotate(tt.args.logTime); err != nil {
 				if tt.err == "" {
 					assert.Equal(err.Error(), "", "test: ["+tt.name+"] return not nil but want nil")
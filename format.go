@@ -416,6 +416,11 @@ func (bf *backendFormatter) Log(calldepth int, r *Record) {
 	bf.b.Log(calldepth+1, &r2)
 }
 
+// ShouldLog implements the Gate interface.
+func (bf *backendFormatter) ShouldLog(r *Record) bool {
+	return ShouldLog(bf.b, r)
+}
+
 // Close closes the log service.
 func (bf *backendFormatter) Close() {
 	bf.b.Close()
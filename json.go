@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// JSONFormatter formats each record as a JSON object, like
+// {"time":"2013-01-01T00:00:00Z","level":"INFO","module":"app","msg":"hello","file":"main.go","line":42}
+type JSONFormatter struct {
+	// Layout of the time field, time.RFC3339Nano if empty
+	TimeLayout string
+	// Report the full path of the caller instead of its base name
+	LongFile bool
+}
+
+type jsonRecord struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Module string `json:"module"`
+	Msg    string `json:"msg"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+}
+
+// Format implements the Formatter interface.
+func (f JSONFormatter) Format(calldepth int, colorful bool, r *Record, output io.Writer) error {
+	_, file, line, ok := runtime.Caller(calldepth + 1)
+	if !ok {
+		file = "???"
+		line = 0
+	} else if !f.LongFile {
+		file = filepath.Base(file)
+	}
+	layout := f.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	msg := r.Message()
+	if !colorful {
+		msg = colorRegexp.ReplaceAllString(msg, "")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(jsonRecord{
+		Time:   r.Time.Format(layout),
+		Level:  r.Level.String(),
+		Module: r.Module,
+		Msg:    msg,
+		File:   file,
+		Line:   line,
+	})
+	if err != nil {
+		return err
+	}
+	// like the other formatters, leave the line separator to the backend
+	_, err = output.Write(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
+	return err
+}
+
+// NewJSONBackend returns a backend writing the records to backend, a
+// FileBackend for example, as JSON lines.
+func NewJSONBackend(backend Backend) Backend {
+	return NewBackendFormatter(backend, JSONFormatter{})
+}
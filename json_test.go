@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONBackend(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "json.log")
+	fileBackend, err := NewDefaultFileBackend(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileBackend.SetRotate(false)
+	SetBackend(NewJSONBackend(fileBackend))
+	log := NewLogger("json")
+	log.Info("line")
+	log.Errorf("%s\n\t\"quoted\" <tag> \x1b[31mred\x1b[0m", "two\nlines")
+	fileBackend.Close()
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var rec jsonRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "INFO", rec.Level)
+	assert.Equal(t, "json", rec.Module)
+	assert.Equal(t, "line", rec.Msg)
+	assert.Equal(t, "json_test.go", rec.File)
+	assert.Equal(t, 23, rec.Line)
+	_, err = time.Parse(time.RFC3339Nano, rec.Time)
+	assert.NoError(t, err)
+
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "ERROR", rec.Level)
+	assert.Equal(t, "two\nlines\n\t\"quoted\" <tag> red", rec.Msg)
+	assert.Equal(t, 24, rec.Line)
+}
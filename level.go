@@ -118,6 +118,11 @@ func (l *moduleLeveled) IsEnabledFor(level Level, module string) bool {
 	return level <= l.GetLevel(module)
 }
 
+// ShouldLog implements the Gate interface.
+func (l *moduleLeveled) ShouldLog(rec *Record) bool {
+	return l.IsEnabledFor(rec.Level, rec.Module) && ShouldLog(l.backend, rec)
+}
+
 func (l *moduleLeveled) Log(calldepth int, rec *Record) {
 	if l.IsEnabledFor(rec.Level, rec.Module) {
 		// TODO get rid of traces of formatter here. BackendFormatter should be used.
@@ -141,6 +141,22 @@ func (l *Logger) IsEnabledFor(level Level) bool {
 	return defaultBackend.IsEnabledFor(level, l.Module)
 }
 
+// ShouldLog returns true if a record of the given level would be written by
+// the backends, which may drop it after its level is checked. Use it to skip
+// building an expensive message. The backend asked is the one records go to,
+// the one set by SetBackend if any.
+func (l *Logger) ShouldLog(level Level) bool {
+	if !l.IsEnabledFor(level) {
+		return false
+	}
+	backend := defaultBackend
+	if l.haveBackend {
+		backend = l.backend
+	}
+	return backend.IsEnabledFor(level, l.Module) &&
+		ShouldLog(backend, &Record{Time: timeNow(), Module: l.Module, Level: level})
+}
+
 // Close waits until all records in the buffered channel have been processed and close service.
 func (l *Logger) Close() {
 	l.lock.Lock()
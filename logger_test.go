@@ -4,7 +4,10 @@
 
 package logging
 
-import "testing"
+import (
+	"path/filepath"
+	"testing"
+)
 
 type Password string
 
@@ -60,3 +63,58 @@ func TestPrivateBackend(t *testing.T) {
 		t.Error("logged to defaultBackend:", MemoryRecordN(privateBackend, 0))
 	}
 }
+
+func TestShouldLog(t *testing.T) {
+	Reset()
+	file, err := NewDefaultFileBackend(filepath.Join(t.TempDir(), "should.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampled := NewSamplingBackend(file, 2)
+	leveled := AddModuleLevel(sampled)
+	leveled.SetLevel(INFO, "")
+	SetBackend(leveled)
+	log := NewLogger("should")
+
+	if log.ShouldLog(DEBUG) {
+		t.Error("debug records are not enabled")
+	}
+	if !log.ShouldLog(INFO) {
+		t.Error("the next info record is forwarded")
+	}
+	log.Info("forwarded")
+	if log.ShouldLog(INFO) {
+		t.Error("the next info record is sampled out")
+	}
+	log.Info("dropped")
+	file.Close()
+	if log.ShouldLog(INFO) {
+		t.Error("the file is closed")
+	}
+
+	// a logger with its own backend asks that one
+	own, err := NewDefaultFileBackend(filepath.Join(t.TempDir(), "own.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownLeveled := AddModuleLevel(own)
+	log.SetBackend(ownLeveled)
+	if !log.ShouldLog(INFO) {
+		t.Error("the own backend is open")
+	}
+	ownLeveled.SetLevel(ERROR, "")
+	if log.ShouldLog(INFO) {
+		t.Error("the own backend drops info records")
+	}
+	own.Close()
+
+	limited := NewRateLimitBackend(&countBackend{}, 1, 1)
+	rec := &Record{Level: INFO}
+	if !ShouldLog(limited, rec) || !ShouldLog(limited, rec) {
+		t.Error("checking does not take the token")
+	}
+	limited.Log(0, rec)
+	if ShouldLog(limited, rec) {
+		t.Error("no token is left")
+	}
+}
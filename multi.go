@@ -33,6 +33,17 @@ func (b *multiLogger) Log(calldepth int, rec *Record) {
 	}
 }
 
+// ShouldLog implements the Gate interface, true if any backend would write
+// rec.
+func (b *multiLogger) ShouldLog(rec *Record) bool {
+	for _, backend := range b.backends {
+		if ShouldLog(backend, rec) {
+			return true
+		}
+	}
+	return false
+}
+
 // Close closes the log service.
 func (b *multiLogger) Close() {
 	for _, backend := range b.backends {
@@ -0,0 +1,180 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	networkDialTimeout  = 5 * time.Second
+	networkWriteTimeout = 5 * time.Second
+	networkMinBackoff   = 10 * time.Millisecond
+)
+
+// NetworkBackend writes records as lines to a TCP or UDP endpoint, eg. a
+// central log aggregator. Like the asynchronous FileBackend, records are
+// queued on a buffered channel and written by a goroutine, so Log does not
+// wait on the network.
+//
+// When the connection breaks, it is redialed with an exponential backoff up
+// to MaxBackoff. Meanwhile up to MaxPending lines are kept, dropping the
+// oldest, and they are flushed once the connection is back.
+type NetworkBackend struct {
+	Network string
+	Address string
+
+	// Set these before the first call to Log.
+	MaxBackoff time.Duration
+	MaxPending int
+
+	statusLock sync.RWMutex
+	status     int8 // 0:close 1:run
+
+	asyncMsgChan chan []byte
+	done         chan struct{}
+
+	// owned by the writer goroutine
+	conn     net.Conn
+	pending  [][]byte
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+// NewNetworkBackend creates a NetworkBackend for address on the named
+// network, see net.Dial. The connection is dialed when the first record is
+// written. asyncLen sets the size of the channel buffer, 1024 by default.
+func NewNetworkBackend(network, address string, asyncLen ...int) (*NetworkBackend, error) {
+	if len(network) == 0 || len(address) == 0 {
+		return nil, errors.New("NetworkBackend must have network and address")
+	}
+	size := 1024
+	if len(asyncLen) > 0 && asyncLen[0] > 0 {
+		size = asyncLen[0]
+	}
+	b := &NetworkBackend{
+		Network:      network,
+		Address:      address,
+		MaxBackoff:   30 * time.Second,
+		MaxPending:   10000,
+		status:       1,
+		asyncMsgChan: make(chan []byte, size),
+		done:         make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// Log implements the Backend interface.
+func (b *NetworkBackend) Log(calldepth int, rec *Record) {
+	b.statusLock.RLock()
+	if b.status == 0 {
+		b.statusLock.RUnlock()
+		return
+	}
+	msg := colorRegexp.ReplaceAll([]byte(rec.Formatted(calldepth+1, false)), []byte{})
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		msg = append(msg, '\n')
+	}
+	b.asyncMsgChan <- msg
+	b.statusLock.RUnlock()
+}
+
+// Close writes the queued records, making one last attempt to connect if
+// needed, and closes the connection.
+func (b *NetworkBackend) Close() {
+	b.statusLock.Lock()
+	if b.status == 0 {
+		b.statusLock.Unlock()
+		return
+	}
+	b.status = 0
+	b.statusLock.Unlock()
+	close(b.asyncMsgChan)
+	<-b.done
+}
+
+func (b *NetworkBackend) run() {
+	defer close(b.done)
+	var retry *time.Timer
+	var retryC <-chan time.Time
+	for {
+		select {
+		case msg, ok := <-b.asyncMsgChan:
+			if !ok {
+				b.nextDial = time.Time{}
+				b.flush()
+				if len(b.pending) > 0 {
+					fmt.Fprintf(os.Stderr, "NetworkLogWriter(%s %s): dropped %d lines on close\n", b.Network, b.Address, len(b.pending))
+				}
+				if b.conn != nil {
+					b.conn.Close()
+				}
+				if retry != nil {
+					retry.Stop()
+				}
+				return
+			}
+			b.queue(msg)
+		case <-retryC:
+			retryC = nil
+		}
+		b.flush()
+		if len(b.pending) > 0 && retryC == nil {
+			retry = time.NewTimer(time.Until(b.nextDial))
+			retryC = retry.C
+		}
+	}
+}
+
+func (b *NetworkBackend) queue(msg []byte) {
+	if b.MaxPending > 0 && len(b.pending) >= b.MaxPending {
+		b.pending = b.pending[1:]
+	}
+	b.pending = append(b.pending, msg)
+}
+
+// flush writes the pending lines, dialing first if disconnected and the
+// backoff has expired.
+func (b *NetworkBackend) flush() {
+	if b.conn == nil {
+		if time.Now().Before(b.nextDial) {
+			return
+		}
+		conn, err := net.DialTimeout(b.Network, b.Address, networkDialTimeout)
+		if err != nil {
+			b.fail(err)
+			return
+		}
+		b.conn = conn
+		b.backoff = 0
+	}
+	for len(b.pending) > 0 {
+		b.conn.SetWriteDeadline(time.Now().Add(networkWriteTimeout))
+		if _, err := b.conn.Write(b.pending[0]); err != nil {
+			b.conn.Close()
+			b.conn = nil
+			b.fail(err)
+			return
+		}
+		b.pending[0] = nil
+		b.pending = b.pending[1:]
+	}
+}
+
+func (b *NetworkBackend) fail(err error) {
+	if b.backoff == 0 {
+		// only report the first failure of an outage
+		fmt.Fprintf(os.Stderr, "NetworkLogWriter(%s %s): %s\n", b.Network, b.Address, err)
+		b.backoff = networkMinBackoff
+	} else {
+		b.backoff *= 2
+	}
+	if b.MaxBackoff > 0 && b.backoff > b.MaxBackoff {
+		b.backoff = b.MaxBackoff
+	}
+	b.nextDial = time.Now().Add(b.backoff)
+}
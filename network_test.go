@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func acceptLines(ln net.Listener) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- s.Text()
+				}
+			}()
+		}
+	}()
+	return lines
+}
+
+func receive(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a line")
+	}
+	return ""
+}
+
+func TestNetworkBackendFlushesAfterOutage(t *testing.T) {
+	Reset()
+	// reserve a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	backend, err := NewNetworkBackend("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.MaxBackoff = 20 * time.Millisecond
+	SetBackend(backend)
+	log := NewLogger("test")
+	log.Info("a")
+	log.Info("b")
+	log.Info("c")
+	time.Sleep(50 * time.Millisecond)
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := acceptLines(ln)
+	assert.Equal(t, "a", receive(t, lines))
+	assert.Equal(t, "b", receive(t, lines))
+	assert.Equal(t, "c", receive(t, lines))
+	backend.Close()
+}
+
+func TestNetworkBackendReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	backend, err := NewNetworkBackend("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	backend.MaxBackoff = 20 * time.Millisecond
+	SetBackend(backend)
+	log := NewLogger("test")
+
+	conn := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			conn <- c
+		}
+	}()
+	log.Info("first")
+	c := <-conn
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", line)
+
+	// drop the connection and come back on the same address
+	c.Close()
+	ln.Close()
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := acceptLines(ln)
+
+	// the first writes to the dead connection may be lost before the
+	// breakage is noticed, keep logging until the new listener gets one
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		log.Info("again")
+		select {
+		case line := <-lines:
+			assert.Equal(t, "again", line)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("backend did not reconnect")
+}
@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package logging
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes on disk for f. The space is reserved
+// without changing the file size, so that appending still starts at the end
+// of the data.
+func preallocate(f *os.File, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+		// the filesystem cannot, the file grows as usual
+		return nil
+	}
+	return err
+}
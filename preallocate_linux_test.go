@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilePreallocate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prealloc.log")
+	fileBackend := NewFileBackend(filename)
+	fileBackend.Preallocate = true
+	fileBackend.MaxSize = 1 << 20
+	fileBackend.MaxLines = 2
+	fileBackend.Daily = false
+	if err := fileBackend.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fileBackend.Close()
+	fileBackend.Write([]byte("line"))
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(5), info.Size())
+	assert.EqualValues(t, 5, fileBackend.maxSizeCurSize.Load())
+	if blocks := allocated(t, filename); blocks < 1<<20 {
+		t.Skipf("%d bytes allocated, the filesystem does not support fallocate", blocks)
+	}
+
+	// the reservation is released on rotation and on Close
+	fileBackend.Write([]byte("line"))
+	fileBackend.Write([]byte("line"))
+	backups, err := fileBackend.BackupFiles()
+	if assert.NoError(t, err) && assert.Len(t, backups, 1) {
+		assert.Less(t, allocated(t, backups[0]), int64(1<<20))
+	}
+	assert.GreaterOrEqual(t, allocated(t, filename), int64(1<<20))
+	fileBackend.Close()
+	assert.Less(t, allocated(t, filename), int64(1<<20))
+}
+
+// allocated returns the disk space taken by filename.
+func allocated(t *testing.T, filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package logging
+
+import "os"
+
+// preallocate is a no-op where fallocate is not available.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitBackend caps the number of records per second forwarded to the
+// wrapped backend with a token bucket. Records over the limit are dropped.
+type RateLimitBackend struct {
+	Backend Backend
+	// Records forwarded per second, <= 0 means no limit
+	RatePerSec float64
+	// Records that can be forwarded at once after a quiet period, at least 1
+	Burst int
+	// Forward a "N messages suppressed" warning before the first record
+	// let through after some were dropped
+	Summary bool
+
+	// theoretical arrival time of the next record in ns, the bucket is full
+	// when it is in the past
+	tat        int64
+	dropped    uint64
+	suppressed uint64
+	now        func() time.Time
+}
+
+// NewRateLimitBackend returns a backend forwarding up to ratePerSec records
+// per second to backend, with bursts of up to burst records.
+func NewRateLimitBackend(backend Backend, ratePerSec float64, burst int) *RateLimitBackend {
+	return &RateLimitBackend{
+		Backend:    backend,
+		RatePerSec: ratePerSec,
+		Burst:      burst,
+		now:        time.Now,
+	}
+}
+
+// Dropped returns the number of records dropped so far.
+func (b *RateLimitBackend) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// allow takes a token from the bucket without locking, or only checks that
+// there is one if take is false.
+func (b *RateLimitBackend) allow(take bool) bool {
+	if b.RatePerSec <= 0 {
+		return true
+	}
+	interval := int64(float64(time.Second) / b.RatePerSec)
+	burst := int64(b.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	clock := b.now
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock().UnixNano()
+	for {
+		tat := atomic.LoadInt64(&b.tat)
+		next := tat
+		if next < now {
+			next = now
+		}
+		next += interval
+		if next-now > interval*burst {
+			return false
+		}
+		if !take || atomic.CompareAndSwapInt64(&b.tat, tat, next) {
+			return true
+		}
+	}
+}
+
+// Log implements the Backend interface.
+func (b *RateLimitBackend) Log(calldepth int, rec *Record) {
+	if !b.allow(true) {
+		atomic.AddUint64(&b.dropped, 1)
+		if b.Summary {
+			atomic.AddUint64(&b.suppressed, 1)
+		}
+		return
+	}
+	if b.Summary {
+		if n := atomic.SwapUint64(&b.suppressed, 0); n > 0 {
+			format := "%d messages suppressed"
+			summary := Record{
+				ID:        rec.ID,
+				Time:      rec.Time,
+				Module:    rec.Module,
+				Level:     WARNING,
+				Args:      []interface{}{n},
+				fmt:       &format,
+				formatter: rec.formatter,
+			}
+			b.Backend.Log(calldepth+1, &summary)
+		}
+	}
+	b.Backend.Log(calldepth+1, rec)
+}
+
+// ShouldLog implements the Gate interface, true if a token is left.
+func (b *RateLimitBackend) ShouldLog(rec *Record) bool {
+	return b.allow(false) && ShouldLog(b.Backend, rec)
+}
+
+// Close closes the wrapped backend.
+func (b *RateLimitBackend) Close() {
+	b.Backend.Close()
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitBackend(t *testing.T) {
+	backend := InitForTesting(DEBUG)
+	limiter := NewRateLimitBackend(backend, 10, 5)
+	limiter.Summary = true
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+	SetBackend(limiter)
+	log := NewLogger("ratelimit")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				log.Info("flood")
+			}
+		}()
+	}
+	wg.Wait()
+	if backend.size != 5 {
+		t.Errorf("%d records forwarded, expected the burst of 5", backend.size)
+	}
+	if limiter.Dropped() != 95 {
+		t.Errorf("%d records dropped, expected 95", limiter.Dropped())
+	}
+
+	// one token is back after 100ms
+	now = now.Add(100 * time.Millisecond)
+	log.Info("again")
+	if backend.size != 7 {
+		t.Fatalf("%d records forwarded, expected 7", backend.size)
+	}
+	if msg := MemoryRecordN(backend, 5).Message(); msg != "95 messages suppressed" {
+		t.Errorf("unexpected summary: %q", msg)
+	}
+	if msg := MemoryRecordN(backend, 6).Message(); msg != "again" {
+		t.Errorf("unexpected message: %q", msg)
+	}
+	log.Info("dropped")
+	if backend.size != 7 {
+		t.Errorf("%d records forwarded, expected 7", backend.size)
+	}
+	if limiter.Dropped() != 96 {
+		t.Errorf("%d records dropped, expected 96", limiter.Dropped())
+	}
+}
+
+func BenchmarkRateLimitBackend(b *testing.B) {
+	limiter := NewRateLimitBackend(&countBackend{}, 1000, 100)
+	rec := &Record{Level: INFO}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			limiter.Log(0, rec)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package logging
+
+// LevelRouterBackend sends each record to the backend set for its level, like
+// warnings and errors to one file and everything else to another.
+type LevelRouterBackend struct {
+	routes map[Level]Backend
+	def    Backend
+}
+
+// NewLevelRouterBackend returns a backend sending the records of each level in
+// routes to its backend, and the records of the other levels to def. Records
+// without a backend are dropped when def is nil. A range of levels is routed
+// by mapping each of them.
+func NewLevelRouterBackend(routes map[Level]Backend, def Backend) *LevelRouterBackend {
+	b := &LevelRouterBackend{routes: make(map[Level]Backend, len(routes)), def: def}
+	for level, backend := range routes {
+		b.routes[level] = backend
+	}
+	return b
+}
+
+// Log implements the Backend interface.
+func (b *LevelRouterBackend) Log(calldepth int, rec *Record) {
+	backend, ok := b.routes[rec.Level]
+	if !ok {
+		backend = b.def
+	}
+	if backend != nil {
+		backend.Log(calldepth+1, rec)
+	}
+}
+
+// ShouldLog implements the Gate interface.
+func (b *LevelRouterBackend) ShouldLog(rec *Record) bool {
+	backend, ok := b.routes[rec.Level]
+	if !ok {
+		backend = b.def
+	}
+	return backend != nil && ShouldLog(backend, rec)
+}
+
+// Close closes every backend once, even if it is used for several levels.
+func (b *LevelRouterBackend) Close() {
+	closed := make(map[Backend]bool)
+	for _, backend := range b.routes {
+		if backend != nil && !closed[backend] {
+			closed[backend] = true
+			backend.Close()
+		}
+	}
+	if b.def != nil && !closed[b.def] {
+		b.def.Close()
+	}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelRouterBackend(t *testing.T) {
+	dir := t.TempDir()
+	app, err := NewDefaultFileBackend(filepath.Join(dir, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := NewDefaultFileBackend(filepath.Join(dir, "app.error.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotation is covered by the file tests
+	app.Rotate, errs.Rotate = false, false
+	router := NewLevelRouterBackend(map[Level]Backend{
+		CRITICAL: errs,
+		ERROR:    errs,
+		WARNING:  errs,
+	}, app)
+	SetBackend(NewBackendFormatter(router, MustStringFormatter("%{level} %{message}")))
+	log := NewLogger("router")
+	log.Info("info")
+	log.Warning("warning")
+	log.Debug("debug")
+	log.Critical("critical")
+	router.Close()
+
+	content, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "INFO info\nDEBUG debug\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "app.error.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "WARNING warning\nCRITICAL critical\n", string(content))
+}
+
+func TestLevelRouterBackendNoDefault(t *testing.T) {
+	inner := &countBackend{}
+	router := NewLevelRouterBackend(map[Level]Backend{ERROR: inner}, nil)
+	SetBackend(router)
+	log := NewLogger("router")
+	log.Error("error")
+	log.Info("dropped")
+	router.Close()
+	assert.Equal(t, int64(1), inner.n)
+}
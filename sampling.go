@@ -0,0 +1,47 @@
+package logging
+
+import "sync/atomic"
+
+// SamplingBackend forwards only one in every Rate records to the wrapped
+// backend. Records at PassLevel or more severe are always forwarded.
+type SamplingBackend struct {
+	Backend Backend
+	// Forward one record in Rate, a Rate <= 1 forwards all of them
+	Rate int
+	// Never sample records at this level or more severe, like ERROR. The
+	// default OFF samples all records.
+	PassLevel Level
+
+	count uint64
+}
+
+// NewSamplingBackend returns a backend forwarding one in every rate records
+// to backend.
+func NewSamplingBackend(backend Backend, rate int) *SamplingBackend {
+	return &SamplingBackend{Backend: backend, Rate: rate}
+}
+
+// Log implements the Backend interface.
+func (b *SamplingBackend) Log(calldepth int, rec *Record) {
+	if b.Rate > 1 && rec.Level > b.PassLevel {
+		// the first record of each run of Rate is forwarded
+		if (atomic.AddUint64(&b.count, 1)-1)%uint64(b.Rate) != 0 {
+			return
+		}
+	}
+	b.Backend.Log(calldepth+1, rec)
+}
+
+// ShouldLog implements the Gate interface, true if rec would be the one
+// forwarded of its run.
+func (b *SamplingBackend) ShouldLog(rec *Record) bool {
+	if b.Rate > 1 && rec.Level > b.PassLevel && atomic.LoadUint64(&b.count)%uint64(b.Rate) != 0 {
+		return false
+	}
+	return ShouldLog(b.Backend, rec)
+}
+
+// Close closes the wrapped backend.
+func (b *SamplingBackend) Close() {
+	b.Backend.Close()
+}
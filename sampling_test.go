@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countBackend counts the records it receives.
+type countBackend struct {
+	n      int64
+	levels sync.Map
+}
+
+func (b *countBackend) Log(calldepth int, rec *Record) {
+	atomic.AddInt64(&b.n, 1)
+	n, _ := b.levels.LoadOrStore(rec.Level, new(int64))
+	atomic.AddInt64(n.(*int64), 1)
+}
+
+func (b *countBackend) Close() {}
+
+func (b *countBackend) count(level Level) int64 {
+	n, ok := b.levels.Load(level)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(n.(*int64))
+}
+
+func TestSamplingBackend(t *testing.T) {
+	inner := &countBackend{}
+	SetBackend(NewSamplingBackend(inner, 10))
+	log := NewLogger("sampling")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Debug("chatty")
+			}
+		}()
+	}
+	wg.Wait()
+	if inner.n != 100 {
+		t.Errorf("%d records forwarded, expected 100", inner.n)
+	}
+}
+
+func TestSamplingBackendPassLevel(t *testing.T) {
+	inner := &countBackend{}
+	backend := NewSamplingBackend(inner, 10)
+	backend.PassLevel = ERROR
+	SetBackend(backend)
+	log := NewLogger("sampling")
+
+	for i := 0; i < 100; i++ {
+		log.Error("error")
+		log.Critical("critical")
+		log.Info("info")
+	}
+	if n := inner.count(ERROR); n != 100 {
+		t.Errorf("%d errors forwarded, expected 100", n)
+	}
+	if n := inner.count(CRITICAL); n != 100 {
+		t.Errorf("%d criticals forwarded, expected 100", n)
+	}
+	if n := inner.count(INFO); n != 10 {
+		t.Errorf("%d infos forwarded, expected 10", n)
+	}
+}
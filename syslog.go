@@ -0,0 +1,63 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+)
+
+// SyslogBackend is a simple logger to syslog backend. It automatically maps
+// the internal log levels to appropriate syslog log levels.
+type SyslogBackend struct {
+	Writer *syslog.Writer
+}
+
+// NewSyslogBackend connects to the syslog daemon at raddr using network, eg.
+// "udp" or "tcp". If network is empty, it connects to the local daemon over
+// its UNIX socket, eg. /dev/log. The facility is combined with the severity
+// of each record, and tag is used as the syslog tag; if empty, os.Args[0] is
+// used.
+//
+// If the connection drops, the syslog package redials it on the next
+// write.
+func NewSyslogBackend(network, raddr, tag string, facility syslog.Priority) (*SyslogBackend, error) {
+	w, err := syslog.Dial(network, raddr, facility, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogBackend{Writer: w}, nil
+}
+
+// Log implements the Backend interface.
+//
+// There are no levels matching LOG_EMERG and LOG_ALERT; CRITICAL is the
+// most severe level and is logged as LOG_CRIT.
+func (b *SyslogBackend) Log(calldepth int, rec *Record) {
+	line := colorRegexp.ReplaceAllString(rec.Formatted(calldepth+1, false), "")
+	var err error
+	switch rec.Level {
+	case CRITICAL:
+		err = b.Writer.Crit(line)
+	case ERROR:
+		err = b.Writer.Err(line)
+	case WARNING:
+		err = b.Writer.Warning(line)
+	case NOTICE:
+		err = b.Writer.Notice(line)
+	case DEBUG, TRACE:
+		err = b.Writer.Debug(line)
+	default:
+		err = b.Writer.Info(line)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to Syslog msg:%s [error]%s\n", line, err.Error())
+	}
+}
+
+// Close closes the connection to the syslog daemon.
+func (b *SyslogBackend) Close() {
+	b.Writer.Close()
+}
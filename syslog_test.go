@@ -0,0 +1,57 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogBackend(t *testing.T) {
+	Reset()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	backend, err := NewSyslogBackend("udp", conn.LocalAddr().String(), "gotest", syslog.LOG_LOCAL0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	SetBackend(backend)
+	log := NewLogger("test")
+
+	tests := []struct {
+		log      func(...interface{})
+		priority string
+	}{
+		{log.Critical, "<130>"},
+		{log.Error, "<131>"},
+		{log.Warning, "<132>"},
+		{log.Notice, "<133>"},
+		{log.Info, "<134>"},
+		{log.Debug, "<135>"},
+	}
+	buf := make([]byte, 1024)
+	for _, test := range tests {
+		test.log("syslog message")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := string(buf[:n])
+		if !strings.HasPrefix(packet, test.priority) {
+			t.Errorf("unexpected priority: %s != %s", packet, test.priority)
+		}
+		if !strings.Contains(packet, "gotest") || !strings.HasSuffix(packet, "syslog message\n") {
+			t.Errorf("unexpected packet: %q", packet)
+		}
+	}
+}